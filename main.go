@@ -1,11 +1,8 @@
 package main
 
 import (
-	"log"
 	"os"
 
-	_ "github.com/garry-sharp/enclave-assessment/pkg/logger"
-
 	"github.com/garry-sharp/enclave-assessment/pkg/logger"
 
 	"github.com/garry-sharp/enclave-assessment/pkg/cli"
@@ -13,23 +10,17 @@ import (
 
 func main() {
 
-	// Set multiwriter logger to file and stdout
-	fn := os.Getenv("LOG_FILE")
-	if fn == "" {
-		fn = "app.log"
-	}
-	l, err := logger.NewFileAndStdOutLogger(fn)
+	// The logger is configured from the --log-* flags once they have been parsed, see cli.LoadCLI
+	cmd, err := cli.LoadCLI()
 	if err != nil {
-		log.Fatalln(err)
+		logger.Error("Failed to load CLI", logger.ErrorKey, err)
+		os.Exit(1)
 	}
-	logger.SetLogger(l)
 
-	cmd, err := cli.LoadCLI()
+	err = cmd.Execute()
+	// Spans are exported in batches, so the last ones are sent before exiting
+	cli.Shutdown()
 	if err != nil {
-		logger.Error("Failed to load CLI")
-		logger.Error(err)
 		os.Exit(1)
 	}
-
-	cmd.Execute()
 }
@@ -8,7 +8,10 @@ import (
 	"errors"
 	"fmt"
 	"math/big"
+	"net/http"
+	"net/url"
 	"strings"
+	"time"
 )
 
 func GetBalances(ctx context.Context, response *APIResponse[[]GetBalancesResponse]) error {
@@ -23,38 +26,88 @@ func GetBalance(ctx context.Context, asset string, response *APIResponse[GetBala
 	return getBalance(ctx, asset, response)
 }
 
-func NewMarketBuyOrder(ctx context.Context, market string, amount *big.Float, response *APIResponse[CreateSpotOrderResponse]) error {
-	body, err := json.Marshal(SpotOrderRequest{
-		Market:    market,
-		QuoteSize: amount.String(),
-		Side:      BUY,
-		Type:      MARKET,
-	})
-	if err != nil {
-		return err
+func GetBook(ctx context.Context, market string, response *APIResponse[GetBookResponse]) error {
+	return getBook(ctx, market, response)
+}
+
+func GetTrades(ctx context.Context, market string, startTime, endTime time.Time, response *APIResponse[[]GetTradesResponse]) error {
+	return getTrades(ctx, market, startTime, endTime, response)
+}
+
+// Lists spot orders with the status, or all of them if status is empty. Only the first page is returned, use
+// GetAllOrders for every page
+func GetOrders(ctx context.Context, status OrderStatus, cursor string, response *APIResponse[GetOrdersResponse]) error {
+	query := url.Values{}
+	if status != "" {
+		query.Set("status", string(status))
 	}
-	err = createSpotOrder(ctx, body, response)
-	if err == nil && response.Error != "" {
-		return fmt.Errorf("error creating order: %s", response.Error)
+	if cursor != "" {
+		query.Set("cursor", cursor)
+	}
+	return getOrders(ctx, query, response)
+}
+
+// Lists spot orders with the status across every page
+func GetAllOrders(ctx context.Context, status OrderStatus) ([]CreateSpotOrderResponse, error) {
+	orders := []CreateSpotOrderResponse{}
+	cursor := ""
+	for {
+		response := APIResponse[GetOrdersResponse]{}
+		if err := GetOrders(ctx, status, cursor, &response); err != nil {
+			return nil, err
+		}
+		orders = append(orders, response.Result.Orders...)
+		if response.Result.NextCursor == "" || response.Result.NextCursor == cursor {
+			return orders, nil
+		}
+		cursor = response.Result.NextCursor
+	}
+}
+
+func GetOrder(ctx context.Context, orderId string, response *APIResponse[CreateSpotOrderResponse]) error {
+	return getOrder(ctx, orderId, response)
+}
+
+// Looks up an order by the client order ID it was placed with. Returns ErrOrderNotFound if there is no such order, e.g.
+// because a request that failed never reached the exchange
+func GetOrderByClientId(ctx context.Context, clientOrderId string, response *APIResponse[CreateSpotOrderResponse]) error {
+	err := getOrderByClientId(ctx, clientOrderId, response)
+	var apiErr *APIError
+	if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound && !errors.Is(err, ErrOrderNotFound) {
+		return fmt.Errorf("%w: %w", ErrOrderNotFound, err)
 	}
 	return err
 }
 
+func NewMarketBuyOrder(ctx context.Context, market string, amount *big.Float, response *APIResponse[CreateSpotOrderResponse]) error {
+	return NewMarketOrder(ctx, market, BUY, QUOTE, amount, "", response)
+}
+
 func NewMarketSellOrder(ctx context.Context, market string, amount *big.Float, response *APIResponse[CreateSpotOrderResponse]) error {
-	body, err := json.Marshal(SpotOrderRequest{
-		Market: market,
-		Size:   amount.String(),
-		Side:   SELL,
-		Type:   MARKET,
-	})
+	return NewMarketOrder(ctx, market, SELL, BASE, amount, "", response)
+}
+
+// creates a market order on either side, sized in the base currency (Size) or the quote currency (QuoteSize). A client
+// order ID, if set, lets the order be looked up with GetOrderByClientId when it isn't known whether the request got
+// through, so it should be the same on every attempt at the same order
+func NewMarketOrder(ctx context.Context, market string, side Side, denomination Denomination, amount *big.Float, clientOrderId string, response *APIResponse[CreateSpotOrderResponse]) error {
+	request := SpotOrderRequest{
+		ClientOrderId: clientOrderId,
+		Market:        market,
+		Side:          side,
+		Type:          MARKET,
+	}
+	if denomination == QUOTE {
+		request.QuoteSize = amount.String()
+	} else {
+		request.Size = amount.String()
+	}
+
+	body, err := json.Marshal(request)
 	if err != nil {
 		return err
 	}
-	err = createSpotOrder(ctx, body, response)
-	if err == nil && response.Error != "" {
-		return fmt.Errorf("error creating order: %s", response.Error)
-	}
-	return err
+	return createSpotOrder(ctx, body, response)
 }
 
 func IsLoggedIn(ctx context.Context) bool {
@@ -69,6 +122,60 @@ func IsLoggedIn(ctx context.Context) bool {
 	return true
 }
 
+// Determines what the API key is allowed to do on a market, which should already be known to exist. Read is checked by
+// fetching the balances. There is no permissions endpoint so trade is checked with a probe order that is guaranteed to
+// be rejected, a post-only limit buy of zero size at the smallest possible price. Only a rejection for its size or the
+// funds behind it shows the request got past the permission check, any other error is returned. If the probe is ever
+// accepted it is canceled straight away
+func GetKeyPermissions(ctx context.Context, market string) (KeyPermissions, error) {
+	permissions := KeyPermissions{}
+
+	balances := APIResponse[[]GetBalancesResponse]{}
+	err := GetBalances(ctx, &balances)
+	if err == nil {
+		permissions.Read = true
+	} else if !errors.Is(err, ErrPermissionDenied) && !errors.Is(err, ErrAuth) {
+		return permissions, err
+	}
+
+	clientOrderId := fmt.Sprintf("permission-probe-%s", GetTimestamp())
+	body, err := json.Marshal(SpotOrderRequest{
+		ClientOrderId: clientOrderId,
+		Market:        market,
+		Price:         "0.00000001",
+		Side:          BUY,
+		Size:          "0",
+		Type:          LIMIT,
+		TimeInForce:   GTC,
+		PostOnly:      true,
+	})
+	if err != nil {
+		return permissions, err
+	}
+	err = createSpotOrder(ctx, body, &APIResponse[CreateSpotOrderResponse]{})
+
+	switch {
+	case err == nil:
+		// Should never happen, but the key can clearly trade. The order mustn't be left on the book
+		permissions.Trade = true
+		if err := CancelOrderByClientId(ctx, clientOrderId); err != nil {
+			return permissions, fmt.Errorf("permission probe order %s was accepted and couldn't be canceled, cancel it by hand: %w", clientOrderId, err)
+		}
+	case errors.Is(err, ErrPermissionDenied) || errors.Is(err, ErrAuth):
+	case errors.Is(err, ErrInvalidSize) || errors.Is(err, ErrInsufficientFunds):
+		// Rejected for the order itself, which is only checked once the key is allowed to place orders
+		permissions.Trade = true
+	default:
+		return permissions, fmt.Errorf("probe order was rejected for a reason that doesn't show whether the key can trade: %w", err)
+	}
+	return permissions, nil
+}
+
+// Cancels an order by the client order ID it was placed with
+func CancelOrderByClientId(ctx context.Context, clientOrderId string) error {
+	return cancelOrderByClientId(ctx, clientOrderId, &APIResponse[any]{})
+}
+
 // returns the base name, base increment, quote name, and quote increment. Error if market doesn't exist
 func GetSpotMarketDetails(ctx context.Context, market string) (string, *big.Float, string, *big.Float, error) {
 	markets := APIResponse[GetMarketsResponse]{}
@@ -99,7 +206,55 @@ func GetSpotMarketDetails(ctx context.Context, market string) (string, *big.Floa
 		}
 	}
 
-	return "", nil, "", nil, fmt.Errorf("market %s does not exist", market)
+	return "", nil, "", nil, fmt.Errorf("%w: %s", ErrMarketNotFound, market)
+}
+
+// returns the mid price of the market from the top of the order book. Error if either side of the book is empty
+func GetMidPrice(ctx context.Context, market string) (*big.Float, error) {
+	book := APIResponse[GetBookResponse]{}
+	err := GetBook(ctx, market, &book)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(book.Result.Bids) == 0 || len(book.Result.Asks) == 0 || len(book.Result.Bids[0]) == 0 || len(book.Result.Asks[0]) == 0 {
+		return nil, fmt.Errorf("no prices available for market %s", market)
+	}
+
+	bid, bidParsed := big.NewFloat(0).SetString(book.Result.Bids[0][0])
+	ask, askParsed := big.NewFloat(0).SetString(book.Result.Asks[0][0])
+	if !bidParsed || !askParsed {
+		return nil, errors.New("unable to parse bid or ask price")
+	}
+
+	mid := new(big.Float).Add(bid, ask)
+	return mid.Quo(mid, big.NewFloat(2)), nil
+}
+
+// returns the volume weighted average price of all public trades in the market between the start and end time. Nil if there were no trades
+func GetVWAP(ctx context.Context, market string, startTime, endTime time.Time) (*big.Float, error) {
+	trades := APIResponse[[]GetTradesResponse]{}
+	err := GetTrades(ctx, market, startTime, endTime, &trades)
+	if err != nil {
+		return nil, err
+	}
+
+	volume := big.NewFloat(0)
+	notional := big.NewFloat(0)
+	for _, trade := range trades.Result {
+		price, priceParsed := big.NewFloat(0).SetString(trade.Price)
+		size, sizeParsed := big.NewFloat(0).SetString(trade.Size)
+		if !priceParsed || !sizeParsed {
+			return nil, errors.New("unable to parse trade price or size")
+		}
+		volume.Add(volume, size)
+		notional.Add(notional, size.Mul(size, price))
+	}
+
+	if volume.Sign() == 0 {
+		return nil, nil
+	}
+	return notional.Quo(notional, volume), nil
 }
 
 func SufficientSpotBalance(ctx context.Context, asset string, amount *big.Float) (bool, error) {
@@ -109,10 +264,6 @@ func SufficientSpotBalance(ctx context.Context, asset string, amount *big.Float)
 		return false, err
 	}
 
-	if balance.Error != "" {
-		return false, fmt.Errorf("error getting balance: %s", balance.Error)
-	}
-
 	balanceValue := big.NewFloat(0)
 	_, okay := balanceValue.SetString(balance.Result.FreeBalance)
 	if !okay {
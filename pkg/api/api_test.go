@@ -2,42 +2,54 @@ package api
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"math/big"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/garry-sharp/enclave-assessment/pkg/logger"
 
 	"github.com/joho/godotenv"
 )
 
+// Whether ../../.env has credentials for the tests that call the Enclave API. The rest run against httptest servers
+var live bool
+
 func setup() {
 	Load(os.Getenv("API_KEY"), os.Getenv("API_SECRET"), os.Getenv("BASE_URL"))
 }
 
+// Points the client at the API in ../../.env, skipping the test without one
+func setupLive(t *testing.T) {
+	if !live {
+		t.Skip("no credentials in ../../.env, skipping live API test")
+	}
+	setup()
+}
+
 func TestMain(m *testing.M) {
-	l, _ := logger.New()
+	l, _ := logger.New(logger.Options{})
 	logger.SetLogger(l)
 
-	err := godotenv.Load("../../.env")
-	if err != nil {
+	if err := godotenv.Load("../../.env"); err != nil {
 		fmt.Println(err)
-		return
-	}
-
-	err = Load(os.Getenv("API_KEY"), os.Getenv("API_SECRET"), os.Getenv("BASE_URL"))
-	if err != nil {
+	} else if err := Load(os.Getenv("API_KEY"), os.Getenv("API_SECRET"), os.Getenv("BASE_URL")); err != nil {
 		fmt.Println(err)
-		return
+	} else {
+		live = true
 	}
 
-	m.Run()
+	os.Exit(m.Run())
 }
 
 func TestGetBalances(t *testing.T) {
-	setup()
+	setupLive(t)
 	ctx, cancel := context.WithCancel(context.Background())
 	cancel()
 	resp := APIResponse[[]GetBalancesResponse]{}
@@ -52,7 +64,7 @@ func TestGetBalances(t *testing.T) {
 	}
 }
 func TestGetMarkets(t *testing.T) {
-	setup()
+	setupLive(t)
 	ctx, cancel := context.WithCancel(context.Background())
 	cancel()
 	resp := APIResponse[GetMarketsResponse]{}
@@ -67,7 +79,7 @@ func TestGetMarkets(t *testing.T) {
 	}
 }
 func TestGetBalance(t *testing.T) {
-	setup()
+	setupLive(t)
 	ctx, cancel := context.WithCancel(context.Background())
 	cancel()
 	resp := APIResponse[GetBalanceResponse]{}
@@ -82,7 +94,7 @@ func TestGetBalance(t *testing.T) {
 	}
 }
 func TestNewMarketBuyOrder(t *testing.T) {
-	setup()
+	setupLive(t)
 	ctx, cancel := context.WithCancel(context.Background())
 	cancel()
 	resp := APIResponse[CreateSpotOrderResponse]{}
@@ -105,7 +117,7 @@ func TestNewMarketBuyOrder(t *testing.T) {
 	}
 }
 func TestNewMarketSellOrder(t *testing.T) {
-	setup()
+	setupLive(t)
 	ctx, cancel := context.WithCancel(context.Background())
 	cancel()
 	resp := APIResponse[CreateSpotOrderResponse]{}
@@ -128,6 +140,7 @@ func TestNewMarketSellOrder(t *testing.T) {
 	}
 }
 func TestIsLoggedIn(t *testing.T) {
+	setupLive(t)
 	defer Load(os.Getenv("API_KEY"), os.Getenv("API_SECRET"), os.Getenv("BASE_URL"))
 	if IsLoggedIn(context.Background()) == false {
 		t.Errorf("expected true, got false")
@@ -139,7 +152,7 @@ func TestIsLoggedIn(t *testing.T) {
 }
 
 func TestGetSpotMarketDetails(t *testing.T) {
-	setup()
+	setupLive(t)
 	base, baseIncrement, quote, quoteIncrement, err := GetSpotMarketDetails(context.Background(), "AVAX-USDC")
 	if err != nil {
 		t.Errorf("unexpected error: %v", err)
@@ -157,7 +170,7 @@ func TestGetSpotMarketDetails(t *testing.T) {
 	}
 }
 func TestSufficientSpotBalance(t *testing.T) {
-	setup()
+	setupLive(t)
 	res1, err1 := SufficientSpotBalance(context.Background(), "AVAX", big.NewFloat(0.001))
 	res2, err2 := SufficientSpotBalance(context.Background(), "AVAX", big.NewFloat(1000000))
 	if err := errors.Join(err1, err2); err != nil {
@@ -170,3 +183,182 @@ func TestSufficientSpotBalance(t *testing.T) {
 		t.Errorf("expected false, got: %v", res2)
 	}
 }
+
+func TestGetMidPrice(t *testing.T) {
+	setupLive(t)
+	price, err := GetMidPrice(context.Background(), "AVAX-USDC")
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if price == nil || price.Sign() <= 0 {
+		t.Errorf("expected positive price, got: %v", price)
+	}
+
+	_, err = GetMidPrice(context.Background(), "AVAX-USDQ")
+	if err == nil {
+		t.Errorf("expected error, got nil")
+	}
+}
+
+func TestNewMarketOrder(t *testing.T) {
+	setupLive(t)
+	ctx := context.Background()
+	resp := APIResponse[CreateSpotOrderResponse]{}
+	err := NewMarketOrder(ctx, "AVAX-USDC", BUY, BASE, big.NewFloat(0.001), "", &resp)
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	err = NewMarketOrder(ctx, "AVAX-USDC", SELL, QUOTE, big.NewFloat(0.01), "", &resp)
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestGetVWAP(t *testing.T) {
+	setupLive(t)
+	endTime := time.Now()
+	_, err := GetVWAP(context.Background(), "AVAX-USDC", endTime.Add(-time.Hour), endTime)
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestGetKeyPermissions(t *testing.T) {
+	defer setup()
+
+	tradeStatus, tradeBody := http.StatusBadRequest, `{"success":false,"error":"size must be positive","error_code":"invalid_size"}`
+	canceled := ""
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodDelete {
+			canceled = strings.TrimPrefix(r.URL.Path, "/v1/orders/client:")
+			w.Write([]byte(`{"success":true}`))
+			return
+		}
+		switch r.URL.Path {
+		case "/v0/wallet/balances":
+			w.Write([]byte(`{"success":true,"result":[]}`))
+		case "/v1/orders":
+			body := SpotOrderRequest{}
+			json.NewDecoder(r.Body).Decode(&body)
+			if body.Size != "0" || !body.PostOnly || body.Type != LIMIT {
+				t.Errorf("unsafe probe order: %+v", body)
+			}
+			w.WriteHeader(tradeStatus)
+			w.Write([]byte(tradeBody))
+		}
+	}))
+	defer server.Close()
+	Load("key", "secret", server.URL)
+
+	permissions, err := GetKeyPermissions(context.Background(), "AVAX-USDC")
+	if err != nil || !permissions.Read || !permissions.Trade || len(permissions.Missing()) != 0 {
+		t.Errorf("expected read and trade, got: %+v, %v", permissions, err)
+	}
+
+	tradeStatus, tradeBody = http.StatusForbidden, `{"success":false,"error":"key is read only","error_code":"permission_denied"}`
+	permissions, err = GetKeyPermissions(context.Background(), "AVAX-USDC")
+	if err != nil || !permissions.Read || permissions.Trade || permissions.Missing()[0] != "trade" {
+		t.Errorf("expected read only, got: %+v, %v", permissions, err)
+	}
+
+	// Rejections that don't show the key got past the permission check are errors
+	for _, status := range []int{http.StatusBadGateway, http.StatusBadRequest} {
+		tradeStatus, tradeBody = status, `{"success":false,"error":"malformed request","error_code":"bad_request"}`
+		if _, err = GetKeyPermissions(context.Background(), "AVAX-USDC"); err == nil {
+			t.Errorf("%d: expected error, got nil", status)
+		}
+	}
+
+	// An accepted probe is canceled
+	tradeStatus, tradeBody = http.StatusOK, `{"success":true,"result":{"orderId":"1"}}`
+	permissions, err = GetKeyPermissions(context.Background(), "AVAX-USDC")
+	if err != nil || !permissions.Trade || !strings.HasPrefix(canceled, "permission-probe-") {
+		t.Errorf("expected the probe to be canceled, got: %+v, %q, %v", permissions, canceled, err)
+	}
+}
+
+func TestGetAllOrders(t *testing.T) {
+	defer setup()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("status") != "closed" {
+			t.Errorf("expected closed orders, got: %s", r.URL.RawQuery)
+		}
+		switch r.URL.Query().Get("cursor") {
+		case "":
+			w.Write([]byte(`{"success":true,"result":{"orders":[{"orderId":"1"}],"nextCursor":"2"}}`))
+		case "2":
+			w.Write([]byte(`{"success":true,"result":{"orders":[{"orderId":"2"}]}}`))
+		}
+	}))
+	defer server.Close()
+	Load("key", "secret", server.URL)
+
+	orders, err := GetAllOrders(context.Background(), CLOSED_ORDERS)
+	if err != nil || len(orders) != 2 || orders[1].OrderId != "2" {
+		t.Errorf("expected two pages of orders, got: %+v, %v", orders, err)
+	}
+}
+
+func TestGetOrder(t *testing.T) {
+	defer setup()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.EscapedPath() != "/v1/orders/a%2Fb" {
+			t.Errorf("expected escaped order id, got: %s", r.URL.EscapedPath())
+		}
+		w.Write([]byte(`{"success":true,"result":{"orderId":"a/b","status":"filled"}}`))
+	}))
+	defer server.Close()
+	Load("key", "secret", server.URL)
+
+	resp := APIResponse[CreateSpotOrderResponse]{}
+	if err := GetOrder(context.Background(), "a/b", &resp); err != nil || resp.Result.Status != "filled" {
+		t.Errorf("expected filled order, got: %+v, %v", resp.Result, err)
+	}
+}
+
+func TestGetOrderByClientId(t *testing.T) {
+	defer setup()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/orders/client:run-1" {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"success":false,"error":"order not found"}`))
+			return
+		}
+		w.Write([]byte(`{"success":true,"result":{"orderId":"1","clientOrderId":"run-1","status":"filled"}}`))
+	}))
+	defer server.Close()
+	Load("key", "secret", server.URL)
+
+	resp := APIResponse[CreateSpotOrderResponse]{}
+	if err := GetOrderByClientId(context.Background(), "run-1", &resp); err != nil || resp.Result.OrderId != "1" {
+		t.Errorf("expected order 1, got: %+v, %v", resp.Result, err)
+	}
+	var apiErr *APIError
+	if err := GetOrderByClientId(context.Background(), "run-2", &resp); !errors.Is(err, ErrOrderNotFound) || !errors.As(err, &apiErr) {
+		t.Errorf("expected order not found, got: %v", err)
+	}
+}
+
+func TestLoadPublic(t *testing.T) {
+	defer setup()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("ENCLAVE-KEY-ID") != "" {
+			t.Errorf("public request was signed")
+		}
+		w.Write([]byte(`{"success":true,"result":{}}`))
+	}))
+	defer server.Close()
+	LoadPublic(server.URL)
+
+	if err := GetMarkets(context.Background(), &APIResponse[GetMarketsResponse]{}); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := GetBalances(context.Background(), &APIResponse[[]GetBalancesResponse]{}); !errors.Is(err, ErrAuth) {
+		t.Errorf("expected auth error, got: %v", err)
+	}
+}
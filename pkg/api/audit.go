@@ -0,0 +1,307 @@
+package api
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/garry-sharp/enclave-assessment/pkg/filelock"
+	"github.com/garry-sharp/enclave-assessment/pkg/logger"
+)
+
+// Returned by VerifyAuditLog when a record was edited, removed, reordered or inserted
+var ErrAuditTampered = errors.New("audit log has been tampered with")
+
+// Returned by VerifyAuditLog when the last line was cut off part way through, e.g. by a crash while it was written.
+// Requests are only sent once their record is written, so a cut off request was never sent, but a cut off response
+// belongs to a request that was and whose outcome is unknown
+var ErrAuditIncomplete = errors.New("audit log ends with an incomplete record")
+
+// The kinds of audit record. Each signed request is written before it is sent, and its response once it arrives
+const (
+	AuditRequest  = "request"
+	AuditResponse = "response"
+)
+
+// A line of the audit log. Request records hold exactly what was signed, response records the raw response to the
+// request at RequestSeq, or the error if none arrived. The secret is never recorded. Hash is the SHA-256 of the previous
+// record's hash followed by this record's JSON with an empty Hash, so changing any record breaks every hash after it
+type AuditRecord struct {
+	Seq        int64     `json:"seq"`
+	Type       string    `json:"type"`
+	Time       time.Time `json:"time"`
+	Method     string    `json:"method,omitempty"`
+	Path       string    `json:"path,omitempty"`
+	Body       string    `json:"body,omitempty"`
+	Timestamp  string    `json:"timestamp,omitempty"`
+	KeyID      string    `json:"keyId,omitempty"`
+	RequestSeq int64     `json:"requestSeq,omitempty"`
+	Status     int       `json:"status,omitempty"`
+	Response   string    `json:"response,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	PrevHash   string    `json:"prevHash"`
+	Hash       string    `json:"hash"`
+}
+
+func (r AuditRecord) hash() (string, error) {
+	r.Hash = ""
+	b, err := json.Marshal(r)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(append([]byte(r.PrevHash), b...))
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// An append-only hash-chained JSONL file of signed requests and their responses. The file is locked while each record
+// is appended, and records other processes appended since are read first, so runs sharing the file extend one chain
+type AuditLog struct {
+	path string
+
+	mu   sync.Mutex
+	file *os.File
+	// The offset the last record read or written ends at, and its sequence number and hash
+	end      int64
+	seq      int64
+	lastHash string
+}
+
+var auditLog *AuditLog
+
+// Records every signed request to the audit log from now on, or stops recording if it is nil
+func SetAuditLog(a *AuditLog) {
+	auditLog = a
+}
+
+// The audit log in the user config directory, e.g. ~/.config/enclave-twap/audit.jsonl
+func DefaultAuditLogPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "enclave-twap", "audit.jsonl"), nil
+}
+
+// Opens the audit log for appending, continuing the chain from its last record. The file is created if it doesn't
+// exist. An incomplete last record left by a crash is removed, see catchUp
+func OpenAuditLog(path string) (*AuditLog, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+	a := &AuditLog{path: path, file: file}
+	if err := a.locked(func() error { return nil }); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return a, nil
+}
+
+func (a *AuditLog) Close() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.file.Close()
+}
+
+// Runs fn with the file locked against other processes, once the records they appended since it was last locked are
+// read. The lock is only held for the one record, so commands running at the same time don't block each other
+func (a *AuditLog) locked(fn func() error) error {
+	if err := filelock.Lock(a.file); err != nil {
+		return fmt.Errorf("unable to lock audit log %s: %w", a.path, err)
+	}
+	defer filelock.Unlock(a.file)
+	if err := a.catchUp(); err != nil {
+		return fmt.Errorf("unable to read audit log %s: %w", a.path, err)
+	}
+	return fn()
+}
+
+// Reads the records after end, so the next one continues the chain from the last of them. A file that has shrunk was
+// replaced or cut short, so it is read again from the start. An incomplete last record is removed with a warning, and
+// if it was a response the outcome of its request is unknown, which is recorded in its place
+func (a *AuditLog) catchUp() error {
+	info, err := a.file.Stat()
+	if err != nil {
+		return err
+	}
+	if info.Size() == a.end {
+		return nil
+	}
+	if info.Size() < a.end {
+		a.end, a.seq, a.lastHash = 0, 0, ""
+	}
+
+	read, err := readAuditLog(io.NewSectionReader(a.file, a.end, info.Size()-a.end), func(record AuditRecord) error {
+		a.seq, a.lastHash = record.Seq, record.Hash
+		return nil
+	})
+	a.end += read
+	if !errors.Is(err, ErrAuditIncomplete) {
+		return err
+	}
+	partial := make([]byte, info.Size()-a.end)
+	if _, err := a.file.ReadAt(partial, a.end); err != nil {
+		return err
+	}
+	if err := a.file.Truncate(a.end); err != nil {
+		return err
+	}
+
+	kind, requestSeq := incompleteRecord(partial)
+	switch {
+	case kind == AuditRequest:
+		logger.Warn("removed an incomplete request record from the end of the audit log, the request was never sent", "path", a.path, "seq", a.seq+1)
+	case kind == AuditResponse && requestSeq > 0:
+		logger.Error("removed an incomplete response record from the end of the audit log, its request was sent but the outcome is unknown, check what it placed", "path", a.path, "seq", a.seq+1, "request_seq", requestSeq)
+		return a.write(AuditRecord{Type: AuditResponse, RequestSeq: requestSeq, Error: "the response record was cut off, the outcome is unknown"})
+	default:
+		logger.Error("removed an incomplete record from the end of the audit log, if it was a response its request was sent and the outcome is unknown", "path", a.path, "seq", a.seq+1)
+	}
+	return nil
+}
+
+// The type of a record cut off part way through and the request it responds to, as far as they were written. Fields are
+// written in the order AuditRecord declares them, so they are usually there. Empty and 0 if not
+func incompleteRecord(partial []byte) (string, int64) {
+	kind := ""
+	if match := incompleteType.FindSubmatch(partial); match != nil {
+		kind = string(match[1])
+	}
+	var requestSeq int64
+	if match := incompleteRequestSeq.FindSubmatch(partial); match != nil {
+		requestSeq, _ = strconv.ParseInt(string(match[1]), 10, 64)
+	}
+	return kind, requestSeq
+}
+
+var (
+	incompleteType       = regexp.MustCompile(`"type":"(\w+)"`)
+	incompleteRequestSeq = regexp.MustCompile(`"requestSeq":(\d+)[,}]`)
+)
+
+// Records a signed request before it is sent and returns its sequence number for the response
+func (a *AuditLog) recordRequest(method, path, body, timestamp, keyID string) (int64, error) {
+	return a.append(AuditRecord{Type: AuditRequest, Method: method, Path: path, Body: body, Timestamp: timestamp, KeyID: keyID})
+}
+
+// Records the response to the request at requestSeq, err is set if no response arrived
+func (a *AuditLog) recordResponse(requestSeq int64, status int, raw []byte, err error) error {
+	record := AuditRecord{Type: AuditResponse, RequestSeq: requestSeq, Status: status, Response: string(raw)}
+	if err != nil {
+		record.Error = err.Error()
+	}
+	_, err = a.append(record)
+	return err
+}
+
+func (a *AuditLog) append(record AuditRecord) (int64, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if err := a.locked(func() error { return a.write(record) }); err != nil {
+		return 0, err
+	}
+	return a.seq, nil
+}
+
+// Writes the record as the next in the chain, with the file locked
+func (a *AuditLog) write(record AuditRecord) error {
+	record.Seq = a.seq + 1
+	record.Time = time.Now().UTC()
+	record.PrevHash = a.lastHash
+	hash, err := record.hash()
+	if err != nil {
+		return err
+	}
+	record.Hash = hash
+
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+	if _, err := a.file.Write(line); err != nil {
+		return fmt.Errorf("unable to write audit log %s: %w", a.path, err)
+	}
+	a.end, a.seq, a.lastHash = a.end+int64(len(line)), record.Seq, record.Hash
+	return nil
+}
+
+// Checks every record's hash and its link to the one before it. Returns the number of records and the last hash, which
+// can be kept elsewhere to detect records being removed from the end. The error wraps ErrAuditTampered and names the
+// first bad line if the chain is broken
+func VerifyAuditLog(path string) (int64, string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, "", err
+	}
+	defer file.Close()
+
+	var count int64
+	lastHash := ""
+	_, err = readAuditLog(file, func(record AuditRecord) error {
+		count++
+		if record.Seq != count {
+			return fmt.Errorf("%w: line %d has sequence number %d", ErrAuditTampered, count, record.Seq)
+		}
+		if record.PrevHash != lastHash {
+			return fmt.Errorf("%w: line %d doesn't follow the line before it", ErrAuditTampered, count)
+		}
+		hash, err := record.hash()
+		if err != nil {
+			return err
+		}
+		if hash != record.Hash {
+			return fmt.Errorf("%w: line %d doesn't match its hash", ErrAuditTampered, count)
+		}
+		lastHash = record.Hash
+		return nil
+	})
+	return count, lastHash, err
+}
+
+// Visits each record in the file. Returns the offset the last complete line ends at, and an error wrapping
+// ErrAuditIncomplete if a line after it was cut off
+func readAuditLog(file io.Reader, visit func(record AuditRecord) error) (int64, error) {
+	var end int64
+	reader := bufio.NewReader(file)
+	for line := 1; ; line++ {
+		b, err := reader.ReadBytes('\n')
+		// Every record is written with its newline in one write, so a line without one was never finished
+		if err == io.EOF && len(b) > 0 {
+			if kind, requestSeq := incompleteRecord(b); kind == AuditResponse && requestSeq > 0 {
+				return end, fmt.Errorf("%w: line %d is the response to request %d, which was sent and whose outcome is unknown", ErrAuditIncomplete, line, requestSeq)
+			}
+			return end, fmt.Errorf("%w: line %d", ErrAuditIncomplete, line)
+		}
+		if len(b) > 0 {
+			record := AuditRecord{}
+			if jsonErr := json.Unmarshal(b, &record); jsonErr != nil {
+				return end, fmt.Errorf("%w: line %d isn't a record: %v", ErrAuditTampered, line, jsonErr)
+			}
+			if visitErr := visit(record); visitErr != nil {
+				return end, visitErr
+			}
+			end += int64(len(b))
+		}
+		if err == io.EOF {
+			return end, nil
+		}
+		if err != nil {
+			return end, err
+		}
+	}
+}
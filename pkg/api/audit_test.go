@@ -0,0 +1,177 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestAuditLog(t *testing.T) {
+	defer setup()
+	defer SetAuditLog(nil)
+
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Write([]byte(`{"success":true,"result":[]}`))
+	}))
+	defer server.Close()
+	Load("key", "secret", server.URL)
+
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	audit, err := OpenAuditLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetAuditLog(audit)
+
+	// Only signed requests are recorded, each as a request and a response
+	GetMarkets(context.Background(), &APIResponse[GetMarketsResponse]{})
+	GetBalances(context.Background(), &APIResponse[[]GetBalancesResponse]{})
+	audit.Close()
+
+	count, _, err := VerifyAuditLog(path)
+	if err != nil || count != 2 {
+		t.Fatalf("expected 2 valid records, got %d, %v", count, err)
+	}
+	b, _ := os.ReadFile(path)
+	if strings.Contains(string(b), "secret") || !strings.Contains(string(b), `"keyId":"key"`) || !strings.Contains(string(b), `"path":"/v0/wallet/balances"`) {
+		t.Errorf("unexpected audit log: %s", b)
+	}
+
+	// Reopening continues the chain
+	audit, _ = OpenAuditLog(path)
+	SetAuditLog(audit)
+	GetBalances(context.Background(), &APIResponse[[]GetBalancesResponse]{})
+	audit.Close()
+	count, _, err = VerifyAuditLog(path)
+	if err != nil || count != 4 {
+		t.Fatalf("expected 4 valid records, got %d, %v", count, err)
+	}
+
+	// A failed write stops the request being sent
+	sent := requests
+	if err := GetBalances(context.Background(), &APIResponse[[]GetBalancesResponse]{}); err == nil || requests != sent {
+		t.Errorf("expected the request not to be sent, got: %v", err)
+	}
+}
+
+func TestVerifyAuditLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	audit, _ := OpenAuditLog(path)
+	for i := 0; i < 3; i++ {
+		seq, _ := audit.recordRequest("POST", "/v1/orders", `{"size":"1"}`, "1", "key")
+		audit.recordResponse(seq, 200, []byte(`{"success":true}`), nil)
+	}
+	audit.Close()
+	original, _ := os.ReadFile(path)
+	lines := strings.SplitAfter(string(original), "\n")
+
+	tampered := map[string]string{
+		"edited":    strings.Replace(string(original), `{\"size\":\"1\"}`, `{\"size\":\"9\"}`, 1),
+		"removed":   lines[0] + strings.Join(lines[2:], ""),
+		"reordered": lines[1] + lines[0] + strings.Join(lines[2:], ""),
+		"garbage":   string(original) + "not json\n",
+	}
+	for name, content := range tampered {
+		os.WriteFile(path, []byte(content), 0600)
+		if _, _, err := VerifyAuditLog(path); !errors.Is(err, ErrAuditTampered) {
+			t.Errorf("%s: expected tampering to be detected, got: %v", name, err)
+		}
+	}
+
+	os.WriteFile(path, original, 0600)
+	if count, last, err := VerifyAuditLog(path); err != nil || count != 6 || last == "" {
+		t.Errorf("expected 6 valid records, got %d, %q, %v", count, last, err)
+	}
+}
+
+func TestAuditLogShared(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+
+	// Logs opened on the same file, like commands running in separate processes, can both write to it
+	first, err := OpenAuditLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer first.Close()
+	second, err := OpenAuditLog(path)
+	if err != nil {
+		t.Fatalf("expected a second process to open the audit log, got: %v", err)
+	}
+	defer second.Close()
+
+	// Each record carries on the chain from the last one in the file, whichever log wrote it
+	wg := sync.WaitGroup{}
+	for _, audit := range []*AuditLog{first, second} {
+		for range 10 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if _, err := audit.recordRequest("GET", "/v1/balances", "", "1", "key"); err != nil {
+					t.Error(err)
+				}
+			}()
+		}
+	}
+	wg.Wait()
+	if count, _, err := VerifyAuditLog(path); err != nil || count != 20 {
+		t.Errorf("expected 20 chained records, got %d, %v", count, err)
+	}
+}
+
+func TestAuditLogIncomplete(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	audit, _ := OpenAuditLog(path)
+	audit.recordRequest("POST", "/v1/orders", `{"size":"1"}`, "1", "key")
+	audit.Close()
+	original, _ := os.ReadFile(path)
+
+	// A crash part way through writing a record leaves a line without its newline
+	os.WriteFile(path, append(original, `{"seq":2,"type":"request","time":"2024-05-01T12:00:00Z","meth`...), 0600)
+	if _, _, err := VerifyAuditLog(path); !errors.Is(err, ErrAuditIncomplete) {
+		t.Errorf("expected the incomplete record to be reported, got: %v", err)
+	}
+
+	// Opening it removes the incomplete record and carries on the chain from the one before
+	audit, err := OpenAuditLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	audit.recordRequest("POST", "/v1/orders", `{"size":"2"}`, "2", "key")
+	audit.Close()
+	if count, _, err := VerifyAuditLog(path); err != nil || count != 2 {
+		t.Errorf("expected 2 valid records, got %d, %v", count, err)
+	}
+
+	// A cut off response belongs to a request that was sent, so verify says which and opening it records the outcome as
+	// unknown in its place
+	original, _ = os.ReadFile(path)
+	os.WriteFile(path, append(original, `{"seq":3,"type":"response","time":"2024-05-01T12:00:00Z","requestSeq":2,"sta`...), 0600)
+	if _, _, err := VerifyAuditLog(path); !errors.Is(err, ErrAuditIncomplete) || !strings.Contains(err.Error(), "response to request 2") {
+		t.Errorf("expected the incomplete response to be reported, got: %v", err)
+	}
+	audit, err = OpenAuditLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	audit.Close()
+	lines := strings.Split(strings.TrimSpace(readFile(t, path)), "\n")
+	if count, _, err := VerifyAuditLog(path); err != nil || count != 3 || !strings.Contains(lines[2], `"type":"response"`) || !strings.Contains(lines[2], `"requestSeq":2`) || !strings.Contains(lines[2], "outcome is unknown") {
+		t.Errorf("expected the unknown outcome of request 2 to be recorded, got %d, %v: %s", count, err, lines[2])
+	}
+}
+
+func readFile(t *testing.T, path string) string {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
@@ -0,0 +1,81 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/garry-sharp/enclave-assessment/pkg/logger"
+)
+
+const (
+	// Skew larger than this is logged as a warning
+	skewWarnThreshold = 2 * time.Second
+	// The Date header only has second precision, so smaller offsets are treated as noise and not corrected
+	minClockCorrection = time.Second
+)
+
+var (
+	// Added to the local time when signing requests, in nanoseconds
+	clockOffset atomic.Int64
+	skewWarned  atomic.Bool
+)
+
+// The current time corrected for the measured offset to the server clock
+func Now() time.Time {
+	return time.Now().Add(ClockOffset())
+}
+
+// The measured offset of the server clock from the local clock, positive if the server is ahead
+func ClockOffset() time.Duration {
+	return time.Duration(clockOffset.Load())
+}
+
+// Measures the offset to the server clock from the Date header of a lightweight request and applies it to signed
+// requests. Returns the offset
+func SyncClock(ctx context.Context) (time.Duration, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, GetConfig().baseURL+"/v1/markets", nil)
+	if err != nil {
+		return 0, err
+	}
+
+	sent := time.Now()
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+
+	if !observeClock(resp, sent, time.Now()) {
+		return 0, fmt.Errorf("server response has no valid Date header")
+	}
+	return ClockOffset(), nil
+}
+
+// Updates the clock offset from the Date header of a response. Returns false if the header is missing or invalid
+func observeClock(resp *http.Response, sent, received time.Time) bool {
+	date, err := http.ParseTime(resp.Header.Get("Date"))
+	if err != nil {
+		return false
+	}
+
+	// The server time is somewhere in the second after the Date header, compare it to the midpoint of the request
+	serverTime := date.Add(500 * time.Millisecond)
+	localTime := sent.Add(received.Sub(sent) / 2)
+	offset := serverTime.Sub(localTime)
+	if offset.Abs() < minClockCorrection {
+		offset = 0
+	}
+	clockOffset.Store(int64(offset))
+
+	if offset.Abs() > skewWarnThreshold {
+		if !skewWarned.Swap(true) {
+			logger.Warn("local clock is out from the server clock, correcting request timestamps", "offset", offset.Round(time.Millisecond))
+		}
+	} else {
+		skewWarned.Store(false)
+	}
+	return true
+}
@@ -0,0 +1,70 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestObserveClock(t *testing.T) {
+	defer clockOffset.Store(0)
+	now := time.Now()
+
+	ahead := &http.Response{Header: http.Header{"Date": []string{now.Add(time.Minute).UTC().Format(http.TimeFormat)}}}
+	if !observeClock(ahead, now, now) {
+		t.Errorf("expected Date header to be parsed")
+	}
+	if offset := ClockOffset(); offset < 59*time.Second || offset > 61*time.Second {
+		t.Errorf("expected ~1m offset, got: %s", offset)
+	}
+	if diff := Now().Sub(time.Now()); diff < 59*time.Second {
+		t.Errorf("expected corrected time to be ~1m ahead, got: %s", diff)
+	}
+
+	inSync := &http.Response{Header: http.Header{"Date": []string{now.UTC().Format(http.TimeFormat)}}}
+	observeClock(inSync, now, now)
+	if offset := ClockOffset(); offset != 0 {
+		t.Errorf("expected sub second offset to be ignored, got: %s", offset)
+	}
+
+	if observeClock(&http.Response{Header: http.Header{}}, now, now) {
+		t.Errorf("expected missing Date header to be rejected")
+	}
+}
+
+func TestTimestampResync(t *testing.T) {
+	defer setup()
+	defer clockOffset.Store(0)
+	serverTime := time.Now().Add(-2 * time.Minute)
+
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Date", serverTime.UTC().Format(http.TimeFormat))
+		if r.Method == http.MethodHead {
+			return
+		}
+		requests++
+		timestamp, _ := strconv.ParseInt(r.Header.Get("ENCLAVE-TIMESTAMP"), 10, 64)
+		if time.UnixMilli(timestamp).Sub(serverTime).Abs() > 5*time.Second {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"success":false,"error":"timestamp expired","error_code":"invalid_timestamp"}`))
+			return
+		}
+		w.Write([]byte(`{"success":true,"result":"hello"}`))
+	}))
+	defer server.Close()
+	Load("key", "secret", server.URL)
+
+	// Skew the clock the wrong way so the first request is rejected
+	clockOffset.Store(int64(time.Hour))
+	response := APIResponse[string]{}
+	if err := do(context.Background(), http.MethodGet, "/authedHello", nil, true, &response); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if requests != 2 || response.Result != "hello" {
+		t.Errorf("expected the corrected request to succeed, got %d requests, result %q", requests, response.Result)
+	}
+}
@@ -0,0 +1,227 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"filippo.io/age"
+)
+
+type Credentials struct {
+	APIKey    string `json:"apiKey"`
+	APISecret string `json:"apiSecret"`
+}
+
+// A source of API credentials, resolved when the command runs rather than passed on the command line
+type CredentialProvider interface {
+	Credentials(ctx context.Context) (Credentials, error)
+}
+
+// Credentials given directly, e.g. from flags
+type StaticProvider Credentials
+
+func (p StaticProvider) Credentials(ctx context.Context) (Credentials, error) {
+	return Credentials(p), validateCredentials(Credentials(p), "static")
+}
+
+// Credentials read from environment variables
+type EnvProvider struct {
+	KeyVar    string
+	SecretVar string
+}
+
+func (p EnvProvider) Credentials(ctx context.Context) (Credentials, error) {
+	creds := Credentials{APIKey: os.Getenv(p.KeyVar), APISecret: os.Getenv(p.SecretVar)}
+	return creds, validateCredentials(creds, fmt.Sprintf("environment variables %s and %s", p.KeyVar, p.SecretVar))
+}
+
+// Credentials printed to stdout by an external command as JSON, in the same style as AWS's credential_process.
+// e.g. {"apiKey": "...", "apiSecret": "..."}. This can be used to read from an OS keyring or a password manager
+type CommandProvider struct {
+	Command string
+	Args    []string
+}
+
+func (p CommandProvider) Credentials(ctx context.Context) (Credentials, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, p.Command, p.Args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return Credentials{}, fmt.Errorf("credential command %s failed: %w: %s", p.Command, err, strings.TrimSpace(stderr.String()))
+	}
+
+	creds := Credentials{}
+	if err := json.Unmarshal(stdout.Bytes(), &creds); err != nil {
+		return Credentials{}, fmt.Errorf("credential command %s must print JSON with apiKey and apiSecret: %w", p.Command, err)
+	}
+	return creds, validateCredentials(creds, "command "+p.Command)
+}
+
+// Credentials stored in a file encrypted with age using a passphrase (scrypt). Passphrase is called when the file is read
+type EncryptedFileProvider struct {
+	Path       string
+	Passphrase func() (string, error)
+}
+
+func (p EncryptedFileProvider) Credentials(ctx context.Context) (Credentials, error) {
+	file, err := os.Open(p.Path)
+	if err != nil {
+		return Credentials{}, err
+	}
+	defer file.Close()
+
+	passphrase, err := p.Passphrase()
+	if err != nil {
+		return Credentials{}, err
+	}
+	identity, err := age.NewScryptIdentity(passphrase)
+	if err != nil {
+		return Credentials{}, err
+	}
+	reader, err := age.Decrypt(file, identity)
+	if err != nil {
+		return Credentials{}, fmt.Errorf("unable to decrypt %s, check the passphrase: %w", p.Path, err)
+	}
+
+	creds := Credentials{}
+	if err := json.NewDecoder(reader).Decode(&creds); err != nil {
+		return Credentials{}, err
+	}
+	return creds, validateCredentials(creds, p.Path)
+}
+
+// Encrypts the credentials with the passphrase and writes them to path, readable only by the current user
+func WriteEncryptedCredentials(path, passphrase string, creds Credentials) error {
+	if err := validateCredentials(creds, path); err != nil {
+		return err
+	}
+	recipient, err := age.NewScryptRecipient(passphrase)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	writer, err := age.Encrypt(file, recipient)
+	if err != nil {
+		return err
+	}
+	if err := json.NewEncoder(writer).Encode(creds); err != nil {
+		return err
+	}
+	return writer.Close()
+}
+
+func validateCredentials(creds Credentials, source string) error {
+	if creds.APIKey == "" || creds.APISecret == "" {
+		return fmt.Errorf("api key and secret not found in %s", source)
+	}
+	return nil
+}
+
+//region Named keys
+
+const keyFileExtension = ".age"
+
+var keyNameRegexp = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// The directory named keys are stored in, one encrypted file per name
+func DefaultKeysDir() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "enclave-twap", "keys"), nil
+}
+
+// The path of the encrypted file for a named key
+func KeyPath(dir, name string) (string, error) {
+	if !keyNameRegexp.MatchString(name) {
+		return "", fmt.Errorf("key name must only contain letters, numbers, - and _, received: %s", name)
+	}
+	return filepath.Join(dir, name+keyFileExtension), nil
+}
+
+// The names of all keys stored in dir
+func ListKeys(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return []string{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	names := []string{}
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.HasSuffix(entry.Name(), keyFileExtension) {
+			names = append(names, strings.TrimSuffix(entry.Name(), keyFileExtension))
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func RemoveKey(dir, name string) error {
+	path, err := KeyPath(dir, name)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("key %s does not exist", name)
+	} else if err != nil {
+		return err
+	}
+	return nil
+}
+
+// Parses a credential reference into a provider. References are one of
+//
+//	env                  API_KEY and API_SECRET environment variables
+//	env:KEY_VAR,SECRET_VAR
+//	file:<name>          a named key in keysDir, see ListKeys
+//	command:<command>    an external command, split on spaces
+func ParseCredentialSource(ref, keysDir string, passphrase func() (string, error)) (CredentialProvider, error) {
+	kind, value, _ := strings.Cut(ref, ":")
+	switch kind {
+	case "env":
+		if value == "" {
+			return EnvProvider{KeyVar: "API_KEY", SecretVar: "API_SECRET"}, nil
+		}
+		keyVar, secretVar, ok := strings.Cut(value, ",")
+		if !ok || keyVar == "" || secretVar == "" {
+			return nil, fmt.Errorf("env credentials must be in the format env:KEY_VAR,SECRET_VAR, received: %s", ref)
+		}
+		return EnvProvider{KeyVar: keyVar, SecretVar: secretVar}, nil
+	case "file":
+		path, err := KeyPath(keysDir, value)
+		if err != nil {
+			return nil, err
+		}
+		return EncryptedFileProvider{Path: path, Passphrase: passphrase}, nil
+	case "command":
+		fields := strings.Fields(value)
+		if len(fields) == 0 {
+			return nil, fmt.Errorf("command credentials must include a command, received: %s", ref)
+		}
+		return CommandProvider{Command: fields[0], Args: fields[1:]}, nil
+	}
+	return nil, fmt.Errorf("credentials must be one of env, env:KEY_VAR,SECRET_VAR, file:<name> or command:<command>, received: %s", ref)
+}
@@ -0,0 +1,129 @@
+package api
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestEncryptedFileProvider(t *testing.T) {
+	dir := t.TempDir()
+	creds := Credentials{APIKey: "key", APISecret: "secret"}
+
+	path, err := KeyPath(dir, "sandbox")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteEncryptedCredentials(path, "correct horse", creds); err != nil {
+		t.Fatal(err)
+	}
+
+	provider := EncryptedFileProvider{Path: path, Passphrase: func() (string, error) { return "correct horse", nil }}
+	got, err := provider.Credentials(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != creds {
+		t.Errorf("expected %v, got: %v", creds, got)
+	}
+
+	provider.Passphrase = func() (string, error) { return "wrong", nil }
+	if _, err := provider.Credentials(context.Background()); err == nil {
+		t.Errorf("expected wrong passphrase to fail")
+	}
+}
+
+func TestKeys(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "keys")
+
+	names, err := ListKeys(dir)
+	if err != nil || len(names) != 0 {
+		t.Errorf("expected no keys in a missing directory, got: %v, %v", names, err)
+	}
+
+	for _, name := range []string{"prod", "sandbox"} {
+		path, _ := KeyPath(dir, name)
+		if err := WriteEncryptedCredentials(path, "pass", Credentials{APIKey: "k", APISecret: "s"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if names, _ := ListKeys(dir); !reflect.DeepEqual(names, []string{"prod", "sandbox"}) {
+		t.Errorf("expected [prod sandbox], got: %v", names)
+	}
+
+	if err := RemoveKey(dir, "prod"); err != nil {
+		t.Fatal(err)
+	}
+	if err := RemoveKey(dir, "prod"); err == nil {
+		t.Errorf("expected removing a missing key to fail")
+	}
+	if names, _ := ListKeys(dir); !reflect.DeepEqual(names, []string{"sandbox"}) {
+		t.Errorf("expected [sandbox], got: %v", names)
+	}
+
+	if _, err := KeyPath(dir, "../escape"); err == nil {
+		t.Errorf("expected invalid key name to fail")
+	}
+}
+
+func TestCommandProvider(t *testing.T) {
+	provider := CommandProvider{Command: "sh", Args: []string{"-c", `echo '{"apiKey": "key", "apiSecret": "secret"}'`}}
+	got, err := provider.Credentials(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.APIKey != "key" || got.APISecret != "secret" {
+		t.Errorf("unexpected credentials: %v", got)
+	}
+
+	for _, script := range []string{"exit 1", "echo not json", `echo '{"apiKey": "key"}'`} {
+		provider := CommandProvider{Command: "sh", Args: []string{"-c", script}}
+		if _, err := provider.Credentials(context.Background()); err == nil {
+			t.Errorf("expected %q to fail", script)
+		}
+	}
+}
+
+func TestEnvProvider(t *testing.T) {
+	t.Setenv("TEST_KEY", "key")
+	t.Setenv("TEST_SECRET", "secret")
+
+	got, err := EnvProvider{KeyVar: "TEST_KEY", SecretVar: "TEST_SECRET"}.Credentials(context.Background())
+	if err != nil || got.APIKey != "key" || got.APISecret != "secret" {
+		t.Errorf("unexpected credentials: %v, %v", got, err)
+	}
+	if _, err := (EnvProvider{KeyVar: "TEST_KEY", SecretVar: "TEST_MISSING"}).Credentials(context.Background()); err == nil {
+		t.Errorf("expected missing secret to fail")
+	}
+}
+
+func TestParseCredentialSource(t *testing.T) {
+	passphrase := func() (string, error) { return "", nil }
+	valid := map[string]CredentialProvider{
+		"env":                   EnvProvider{KeyVar: "API_KEY", SecretVar: "API_SECRET"},
+		"env:PROD_KEY,PROD_SEC": EnvProvider{KeyVar: "PROD_KEY", SecretVar: "PROD_SEC"},
+		"command:pass show enc": CommandProvider{Command: "pass", Args: []string{"show", "enc"}},
+	}
+	for ref, expected := range valid {
+		provider, err := ParseCredentialSource(ref, "/keys", passphrase)
+		if err != nil {
+			t.Errorf("unexpected error for %s: %v", ref, err)
+			continue
+		}
+		if !reflect.DeepEqual(provider, expected) {
+			t.Errorf("expected %v for %s, got: %v", expected, ref, provider)
+		}
+	}
+
+	provider, err := ParseCredentialSource("file:prod", "/keys", passphrase)
+	if file, ok := provider.(EncryptedFileProvider); err != nil || !ok || file.Path != filepath.Join("/keys", "prod.age") {
+		t.Errorf("unexpected file provider: %v, %v", provider, err)
+	}
+
+	for _, ref := range []string{"", "vault:x", "env:ONLY", "command:", "file:../x"} {
+		if _, err := ParseCredentialSource(ref, "/keys", passphrase); err == nil {
+			t.Errorf("expected %q to fail", ref)
+		}
+	}
+}
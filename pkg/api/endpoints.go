@@ -3,108 +3,64 @@ package api
 //unexported raw http requests
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
+	"fmt"
 	"net/http"
+	"net/url"
+	"time"
 )
 
 func authHello(ctx context.Context, response *APIResponse[string]) error {
-	path := "/authedHello"
-	method := http.MethodGet
-	body := ""
-	timestamp := GetTimestamp()
-
-	req, err := http.NewRequestWithContext(ctx, method, GetConfig().baseURL+path, nil)
-	if err != nil {
-		return err
-	}
-
-	AddAuth(req, timestamp, method, path, body)
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
-	return json.NewDecoder(resp.Body).Decode(response)
+	return do(ctx, http.MethodGet, "/authedHello", nil, true, response)
 }
 
 func getMarkets(ctx context.Context, response *APIResponse[GetMarketsResponse]) error {
-	path := "/v1/markets"
-	method := http.MethodGet
-
-	req, err := http.NewRequestWithContext(ctx, method, GetConfig().baseURL+path, nil)
-	if err != nil {
-		return err
-	}
-
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
-	return json.NewDecoder(resp.Body).Decode(response)
+	return do(ctx, http.MethodGet, "/v1/markets", nil, false, response)
 }
 
 func getBalances(ctx context.Context, response *APIResponse[[]GetBalancesResponse]) error {
-	path := "/v0/wallet/balances"
-	method := http.MethodGet
-	body := ""
-	timestamp := GetTimestamp()
-
-	req, err := http.NewRequestWithContext(ctx, method, GetConfig().baseURL+path, nil)
-	if err != nil {
-		return err
-	}
-
-	AddAuth(req, timestamp, method, path, body)
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
-	return json.NewDecoder(resp.Body).Decode(response)
+	return do(ctx, http.MethodGet, "/v0/wallet/balances", nil, true, response)
 }
 
 func getBalance(ctx context.Context, asset string, response *APIResponse[GetBalanceResponse]) error {
-	path := "/v0/get_balance"
-	method := http.MethodPost
 	body, err := json.Marshal(map[string]string{"symbol": asset})
 	if err != nil {
 		return err
 	}
-	timestamp := GetTimestamp()
+	return do(ctx, http.MethodPost, "/v0/get_balance", body, true, response)
+}
 
-	req, err := http.NewRequestWithContext(ctx, method, GetConfig().baseURL+path, bytes.NewBuffer([]byte(body)))
+func createSpotOrder(ctx context.Context, body []byte, response *APIResponse[CreateSpotOrderResponse]) error {
+	return do(ctx, http.MethodPost, "/v1/orders", body, true, response)
+}
 
-	if err != nil {
-		return err
-	}
+func getBook(ctx context.Context, market string, response *APIResponse[GetBookResponse]) error {
+	path := "/v1/book?market=" + url.QueryEscape(market) + "&depth=1"
+	return do(ctx, http.MethodGet, path, nil, false, response)
+}
 
-	AddAuth(req, timestamp, method, path, string(body))
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
-	return json.NewDecoder(resp.Body).Decode(response)
+func getTrades(ctx context.Context, market string, startTime, endTime time.Time, response *APIResponse[[]GetTradesResponse]) error {
+	path := fmt.Sprintf("/v1/trades?market=%s&startTime=%d&endTime=%d", url.QueryEscape(market), startTime.UnixMilli(), endTime.UnixMilli())
+	return do(ctx, http.MethodGet, path, nil, false, response)
 }
 
-func createSpotOrder(ctx context.Context, body []byte, response *APIResponse[CreateSpotOrderResponse]) error {
+func getOrders(ctx context.Context, query url.Values, response *APIResponse[GetOrdersResponse]) error {
 	path := "/v1/orders"
-	method := http.MethodPost
-	timestamp := GetTimestamp()
-
-	reqObj, err := http.NewRequestWithContext(ctx, method, GetConfig().baseURL+path, bytes.NewBuffer(body))
-	if err != nil {
-		return err
+	if len(query) > 0 {
+		path += "?" + query.Encode()
 	}
+	return do(ctx, http.MethodGet, path, nil, true, response)
+}
 
-	AddAuth(reqObj, timestamp, method, path, string(body))
-	resp, err := http.DefaultClient.Do(reqObj)
-	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
-	return json.NewDecoder(resp.Body).Decode(response)
+func getOrder(ctx context.Context, orderId string, response *APIResponse[CreateSpotOrderResponse]) error {
+	return do(ctx, http.MethodGet, "/v1/orders/"+url.PathEscape(orderId), nil, true, response)
+}
+
+func cancelOrderByClientId(ctx context.Context, clientOrderId string, response *APIResponse[any]) error {
+	return do(ctx, http.MethodDelete, "/v1/orders/client:"+url.PathEscape(clientOrderId), nil, true, response)
+}
+
+func getOrderByClientId(ctx context.Context, clientOrderId string, response *APIResponse[CreateSpotOrderResponse]) error {
+	return do(ctx, http.MethodGet, "/v1/orders/client:"+url.PathEscape(clientOrderId), nil, true, response)
 }
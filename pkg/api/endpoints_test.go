@@ -4,10 +4,11 @@ import (
 	"context"
 	"encoding/json"
 	"testing"
+	"time"
 )
 
 func TestEndpointgetMarkets(t *testing.T) {
-	setup()
+	setupLive(t)
 	markets := APIResponse[GetMarketsResponse]{}
 	ctx := context.Background()
 	err := getMarkets(ctx, &markets)
@@ -20,7 +21,7 @@ func TestEndpointgetMarkets(t *testing.T) {
 }
 
 func TestEndpointgetBalances(t *testing.T) {
-	setup()
+	setupLive(t)
 	balances := APIResponse[[]GetBalancesResponse]{}
 	ctx := context.Background()
 	err := getBalances(ctx, &balances)
@@ -33,7 +34,7 @@ func TestEndpointgetBalances(t *testing.T) {
 }
 
 func TestEndpointgetBalance(t *testing.T) {
-	setup()
+	setupLive(t)
 	balance := APIResponse[GetBalanceResponse]{}
 	ctx := context.Background()
 	err := getBalance(ctx, "AVAX", &balance)
@@ -47,7 +48,7 @@ func TestEndpointgetBalance(t *testing.T) {
 }
 
 func TestEndpointcreateSpotOrder(t *testing.T) {
-	setup()
+	setupLive(t)
 	body1, _ := json.Marshal(SpotOrderRequest{
 		Market:    "AVAX-USDC",
 		QuoteSize: "0.01",
@@ -81,3 +82,30 @@ func TestEndpointcreateSpotOrder(t *testing.T) {
 		t.Error(response.Error)
 	}
 }
+
+func TestEndpointgetBook(t *testing.T) {
+	setupLive(t)
+	book := APIResponse[GetBookResponse]{}
+	ctx := context.Background()
+	err := getBook(ctx, "AVAX-USDC", &book)
+	if err != nil {
+		t.Error(err)
+	}
+	if book.Error != "" {
+		t.Error(book.Error)
+	}
+}
+
+func TestEndpointgetTrades(t *testing.T) {
+	setupLive(t)
+	trades := APIResponse[[]GetTradesResponse]{}
+	ctx := context.Background()
+	endTime := time.Now()
+	err := getTrades(ctx, "AVAX-USDC", endTime.Add(-time.Hour), endTime, &trades)
+	if err != nil {
+		t.Error(err)
+	}
+	if trades.Error != "" {
+		t.Error(trades.Error)
+	}
+}
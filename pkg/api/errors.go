@@ -0,0 +1,143 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Sentinel errors for the Enclave error codes callers need to act on. An *APIError matches these with errors.Is
+var (
+	ErrAuth              = errors.New("authentication failed")
+	ErrInsufficientFunds = errors.New("insufficient funds")
+	ErrMarketNotFound    = errors.New("market not found")
+	ErrRateLimited       = errors.New("rate limited")
+	ErrInvalidSize       = errors.New("invalid order size")
+	ErrTimestampExpired  = errors.New("request timestamp expired")
+	ErrPermissionDenied  = errors.New("permission denied")
+	ErrOrderNotFound     = errors.New("order not found")
+)
+
+// Maps the error_code values returned by Enclave to the sentinel errors. Enclave doesn't publish a list of its codes, only
+// insufficient_funds has been seen from it, so the rest are best guesses and matching falls back on the HTTP status and,
+// for insufficient funds, the message
+var errorCodes = map[string]error{
+	"unauthorized":             ErrAuth,
+	"unauthenticated":          ErrAuth,
+	"invalid_api_key":          ErrAuth,
+	"invalid_signature":        ErrAuth,
+	"insufficient_funds":       ErrInsufficientFunds,
+	"insufficient_balance":     ErrInsufficientFunds,
+	"market_not_found":         ErrMarketNotFound,
+	"invalid_market":           ErrMarketNotFound,
+	"rate_limited":             ErrRateLimited,
+	"too_many_requests":        ErrRateLimited,
+	"invalid_size":             ErrInvalidSize,
+	"invalid_quote_size":       ErrInvalidSize,
+	"size_too_small":           ErrInvalidSize,
+	"size_too_large":           ErrInvalidSize,
+	"invalid_timestamp":        ErrTimestampExpired,
+	"timestamp_expired":        ErrTimestampExpired,
+	"expired_timestamp":        ErrTimestampExpired,
+	"forbidden":                ErrPermissionDenied,
+	"permission_denied":        ErrPermissionDenied,
+	"insufficient_permissions": ErrPermissionDenied,
+	"order_not_found":          ErrOrderNotFound,
+}
+
+// An error returned by the Enclave API, either in the response body or as an unsuccessful HTTP status
+type APIError struct {
+	StatusCode int
+	Code       string
+	Message    string
+	Path       string
+	// Parsed from the Retry-After header, zero if it wasn't set
+	RetryAfter time.Duration
+}
+
+func (e *APIError) Error() string {
+	message := e.Message
+	if message == "" {
+		message = http.StatusText(e.StatusCode)
+	}
+	if e.Code != "" {
+		return fmt.Sprintf("api error on %s: %s (%s, status %d)", e.Path, message, e.Code, e.StatusCode)
+	}
+	return fmt.Sprintf("api error on %s: %s (status %d)", e.Path, message, e.StatusCode)
+}
+
+// Returns the sentinel error for the error code, falling back to the message for insufficient funds and then the HTTP
+// status. Nil if none are known
+func (e *APIError) Unwrap() error {
+	if err, ok := errorCodes[strings.ToLower(e.Code)]; ok {
+		return err
+	}
+	if isInsufficientFundsMessage(e.Message) {
+		return ErrInsufficientFunds
+	}
+	switch e.StatusCode {
+	case http.StatusUnauthorized:
+		return ErrAuth
+	case http.StatusForbidden:
+		return ErrPermissionDenied
+	case http.StatusTooManyRequests:
+		return ErrRateLimited
+	}
+	return nil
+}
+
+// Matches messages such as "Insufficient balance" or "insufficient funds for order", but not insufficient permissions
+func isInsufficientFundsMessage(message string) bool {
+	message = strings.ToLower(message)
+	return strings.Contains(message, "insufficient") && (strings.Contains(message, "fund") || strings.Contains(message, "balance"))
+}
+
+// Implemented by APIResponse so errors can be read from any result type
+type errorResponse interface {
+	errorFields() (message, code string)
+}
+
+func (r *APIResponse[T]) errorFields() (string, string) {
+	return r.Error, r.ErrorCode
+}
+
+// Returns an *APIError if the decoded response contains an error or the status is unsuccessful, otherwise nil
+func responseError(resp *http.Response, path string, response errorResponse) error {
+	message, code := response.errorFields()
+	if message == "" && code == "" && resp.StatusCode < http.StatusBadRequest {
+		return nil
+	}
+	return newAPIError(resp, path, code, message)
+}
+
+func newAPIError(resp *http.Response, path, code, message string) *APIError {
+	if i := strings.Index(path, "?"); i != -1 {
+		path = path[:i]
+	}
+	return &APIError{
+		StatusCode: resp.StatusCode,
+		Code:       code,
+		Message:    message,
+		Path:       path,
+		RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After")),
+	}
+}
+
+// Parses a Retry-After header given in either seconds or as an HTTP date
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		if wait := time.Until(date); wait > 0 {
+			return wait
+		}
+	}
+	return 0
+}
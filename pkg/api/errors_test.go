@@ -0,0 +1,87 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestAPIErrorIs(t *testing.T) {
+	tests := []struct {
+		err      *APIError
+		expected error
+	}{
+		{err: &APIError{StatusCode: http.StatusBadRequest, Code: "insufficient_funds"}, expected: ErrInsufficientFunds},
+		{err: &APIError{StatusCode: http.StatusBadRequest, Code: "INVALID_MARKET"}, expected: ErrMarketNotFound},
+		{err: &APIError{StatusCode: http.StatusBadRequest, Code: "size_too_small"}, expected: ErrInvalidSize},
+		{err: &APIError{StatusCode: http.StatusUnauthorized}, expected: ErrAuth},
+		{err: &APIError{StatusCode: http.StatusTooManyRequests}, expected: ErrRateLimited},
+		{err: &APIError{StatusCode: http.StatusBadRequest, Code: "something_else"}, expected: nil},
+		{err: &APIError{StatusCode: http.StatusBadRequest, Message: "Insufficient balance for order"}, expected: ErrInsufficientFunds},
+		{err: &APIError{StatusCode: http.StatusForbidden, Message: "insufficient permissions"}, expected: ErrPermissionDenied},
+	}
+
+	for _, tt := range tests {
+		wrapped := fmt.Errorf("wrapped: %w", tt.err)
+		if tt.expected != nil && !errors.Is(wrapped, tt.expected) {
+			t.Errorf("expected %v to match %v", tt.err, tt.expected)
+		}
+		if tt.expected == nil && tt.err.Unwrap() != nil {
+			t.Errorf("expected %v to match nothing, got: %v", tt.err, tt.err.Unwrap())
+		}
+
+		var apiErr *APIError
+		if !errors.As(wrapped, &apiErr) || apiErr.StatusCode != tt.err.StatusCode {
+			t.Errorf("expected errors.As to find %v", tt.err)
+		}
+	}
+}
+
+func TestAPIErrorMessage(t *testing.T) {
+	err1 := &APIError{StatusCode: 400, Code: "insufficient_funds", Message: "not enough USDC", Path: "/v1/orders"}
+	err2 := &APIError{StatusCode: 502, Path: "/v1/markets"}
+	if err1.Error() != "api error on /v1/orders: not enough USDC (insufficient_funds, status 400)" {
+		t.Errorf("unexpected message: %s", err1.Error())
+	}
+	if err2.Error() != "api error on /v1/markets: Bad Gateway (status 502)" {
+		t.Errorf("unexpected message: %s", err2.Error())
+	}
+}
+
+func TestResponseError(t *testing.T) {
+	ok := APIResponse[string]{Success: true}
+	if err := responseError(&http.Response{StatusCode: 200}, "/authedHello", &ok); err != nil {
+		t.Errorf("expected nil, got: %v", err)
+	}
+
+	failed := APIResponse[string]{Error: "market not found", ErrorCode: "market_not_found"}
+	err := responseError(&http.Response{StatusCode: 400}, "/v1/book?market=ABC-DEF", &failed)
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.Path != "/v1/book" || !errors.Is(err, ErrMarketNotFound) {
+		t.Errorf("unexpected error: %#v", err)
+	}
+}
+
+func TestResponseErrorStatus(t *testing.T) {
+	resp := &http.Response{StatusCode: http.StatusTooManyRequests, Header: http.Header{"Retry-After": []string{"3"}}}
+	err := responseError(resp, "/v1/orders", &APIResponse[string]{})
+
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || !errors.Is(err, ErrRateLimited) || apiErr.RetryAfter != 3*time.Second {
+		t.Errorf("unexpected error: %#v", err)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	if wait := parseRetryAfter("2"); wait != 2*time.Second {
+		t.Errorf("expected 2s, got: %s", wait)
+	}
+	if wait := parseRetryAfter(time.Now().Add(time.Minute).UTC().Format(http.TimeFormat)); wait < 58*time.Second || wait > time.Minute {
+		t.Errorf("expected ~1m, got: %s", wait)
+	}
+	if wait := parseRetryAfter("soon"); wait != 0 {
+		t.Errorf("expected 0, got: %s", wait)
+	}
+}
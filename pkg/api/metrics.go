@@ -0,0 +1,52 @@
+package api
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	requestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "enclave_api_requests_total",
+		Help: "Requests sent to the Enclave API by endpoint, method and status code. The status is error if no response arrived",
+	}, []string{"endpoint", "method", "status"})
+	requestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name: "enclave_api_request_duration_seconds",
+		Help: "Time from sending a request to the Enclave API to receiving its response headers",
+	}, []string{"endpoint", "method"})
+	rateLimitWaits = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "enclave_api_rate_limit_waits_total",
+		Help: "Requests delayed by the rate limiter",
+	})
+	rateLimitWaitSeconds = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "enclave_api_rate_limit_wait_seconds_total",
+		Help: "Time requests spent waiting for the rate limiter",
+	})
+	retriesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "enclave_api_retries_total",
+		Help: "Retries scheduled by the retry policy by error class",
+	}, []string{"class"})
+)
+
+// The path without its query and with IDs replaced, so each endpoint is a single series
+func endpointLabel(path string) string {
+	path, _, _ = strings.Cut(path, "?")
+	if strings.HasPrefix(path, "/v1/orders/") {
+		return "/v1/orders/{id}"
+	}
+	return path
+}
+
+func observeRequest(method, path string, status int, duration time.Duration) {
+	endpoint := endpointLabel(path)
+	label := "error"
+	if status != 0 {
+		label = strconv.Itoa(status)
+	}
+	requestsTotal.WithLabelValues(endpoint, method, label).Inc()
+	requestDuration.WithLabelValues(endpoint, method).Observe(duration.Seconds())
+}
@@ -1,8 +1,22 @@
 package api
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
 	"time"
+
+	"github.com/garry-sharp/enclave-assessment/pkg/logger"
+	"github.com/garry-sharp/enclave-assessment/pkg/tracing"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 type Config struct {
@@ -25,16 +39,173 @@ func Load(apiKey, apiSecret, baseURL string) error {
 	config = &Config{
 		apiKey:    apiKey,
 		apiSecret: apiSecret,
-		baseURL:   baseURL,
+		baseURL:   strings.TrimRight(baseURL, "/"),
 	}
 
 	return nil
 }
 
+// Configures only the base URL, for commands that just use public endpoints. Authenticated requests fail with ErrAuth
+func LoadPublic(baseURL string) error {
+	if baseURL == "" {
+		return fmt.Errorf("variable baseURL must be set")
+	}
+	config = &Config{baseURL: strings.TrimRight(baseURL, "/")}
+	return nil
+}
+
 func GetConfig() *Config {
 	return config
 }
 
+// The timestamp used to sign requests, corrected for the offset to the server clock
 func GetTimestamp() string {
-	return fmt.Sprintf("%d", time.Now().UnixMilli())
+	return fmt.Sprintf("%d", Now().UnixMilli())
+}
+
+const (
+	// Responses larger than this are truncated before decoding
+	maxResponseBytes = 10 << 20
+	// How much of an unexpected body is kept in the error message
+	maxSnippetBytes = 256
+)
+
+// Each request is a client span, and carries its context to the server in the traceparent header
+var httpClient = &http.Client{Transport: otelhttp.NewTransport(http.DefaultTransport)}
+
+// Executes a request against the API and decodes the response. The path may include a query string, which is signed along
+// with the rest of the path for authenticated requests. Unsuccessful statuses and errors in the body are returned as an
+// *APIError, including when the body is empty or isn't JSON. If a signed request is rejected for its timestamp the clock
+// is re-synced with the server and the request is sent once more
+func do[T any](ctx context.Context, method, path string, body []byte, authed bool, response *APIResponse[T]) (err error) {
+	ctx, span := tracing.Start(ctx, "api.request", attribute.String("http.request.method", method), attribute.String("url.path", endpointLabel(path)))
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+
+	err = doOnce(ctx, method, path, body, authed, response)
+	if !authed || !errors.Is(err, ErrTimestampExpired) {
+		return err
+	}
+
+	if _, syncErr := SyncClock(ctx); syncErr != nil {
+		return err
+	}
+	*response = APIResponse[T]{}
+	return doOnce(ctx, method, path, body, authed, response)
+}
+
+func doOnce[T any](ctx context.Context, method, path string, body []byte, authed bool, response *APIResponse[T]) error {
+	var reqBody io.Reader
+	if body != nil {
+		reqBody = bytes.NewReader(body)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, GetConfig().baseURL+path, reqBody)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	// Time spent waiting, signing and on the round trip are separate spans, so a slow request shows which it was
+	_, waitSpan := tracing.Start(ctx, "rate_limit.wait")
+	err = limiter.wait(ctx)
+	tracing.RecordError(waitSpan, err)
+	waitSpan.End()
+	if err != nil {
+		return err
+	}
+
+	// Signed after waiting so the timestamp isn't stale. Signed requests are written to the audit log before they are
+	// sent, and aren't sent if that fails
+	audit := auditLog
+	var auditSeq int64
+	if authed {
+		if GetConfig().apiKey == "" {
+			return fmt.Errorf("%w: %s requires an api key and secret", ErrAuth, req.URL.Path)
+		}
+		_, signSpan := tracing.Start(ctx, "sign", attribute.Bool("audit", audit != nil))
+		auditSeq, err = sign(req, audit, method, path, body)
+		tracing.RecordError(signSpan, err)
+		signSpan.End()
+		if err != nil {
+			return err
+		}
+	}
+
+	// Logged with the caller's fields, e.g. the run ID and slice of a TWAP order
+	log := logger.FromContext(ctx).With("method", method, "path", req.URL.Path)
+	sent := time.Now()
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		observeRequest(method, path, 0, time.Since(sent))
+		log.Debug("api request failed", "duration_ms", time.Since(sent).Milliseconds(), logger.ErrorKey, err)
+		recordResponse(log, audit, auditSeq, 0, nil, err)
+		return err
+	}
+	defer resp.Body.Close()
+	observeClock(resp, sent, time.Now())
+	observeRequest(method, path, resp.StatusCode, time.Since(sent))
+	log.Debug("api request", "status", resp.StatusCode, "duration_ms", time.Since(sent).Milliseconds())
+
+	raw, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseBytes))
+	recordResponse(log, audit, auditSeq, resp.StatusCode, raw, err)
+	if err != nil {
+		return err
+	}
+
+	if len(bytes.TrimSpace(raw)) == 0 {
+		if resp.StatusCode >= http.StatusBadRequest {
+			return responseError(resp, path, response)
+		}
+		return nil
+	}
+
+	if err := json.Unmarshal(raw, response); err != nil {
+		if resp.StatusCode >= http.StatusBadRequest {
+			return newAPIError(resp, path, "", snippet(raw))
+		}
+		return fmt.Errorf("unable to decode response from %s (status %d): %w, body: %s", req.URL.Path, resp.StatusCode, err, snippet(raw))
+	}
+
+	return responseError(resp, path, response)
+}
+
+// Adds the auth headers and records the request in the audit log if there is one, returning its audit sequence number
+func sign(req *http.Request, audit *AuditLog, method, path string, body []byte) (int64, error) {
+	timestamp := GetTimestamp()
+	if err := AddAuth(req, timestamp, method, path, string(body)); err != nil {
+		return 0, err
+	}
+	if audit == nil {
+		return 0, nil
+	}
+	seq, err := audit.recordRequest(method, path, string(body), timestamp, GetConfig().apiKey)
+	if err != nil {
+		return 0, fmt.Errorf("request not sent: %w", err)
+	}
+	return seq, nil
+}
+
+// Writes the response to a signed request to the audit log. The request has already been sent, so a failure is logged
+// rather than returned, which could cause an order to be retried
+func recordResponse(log *slog.Logger, audit *AuditLog, requestSeq int64, status int, raw []byte, err error) {
+	if audit == nil || requestSeq == 0 {
+		return
+	}
+	if auditErr := audit.recordResponse(requestSeq, status, raw, err); auditErr != nil {
+		log.Error("unable to record the response in the audit log", "request_seq", requestSeq, logger.ErrorKey, auditErr)
+	}
+}
+
+// Returns the start of a response body for error messages
+func snippet(raw []byte) string {
+	s := string(bytes.TrimSpace(raw))
+	if len(s) > maxSnippetBytes {
+		return s[:maxSnippetBytes] + "..."
+	}
+	return s
 }
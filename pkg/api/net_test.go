@@ -1,6 +1,20 @@
 package api
 
-import "testing"
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/garry-sharp/enclave-assessment/pkg/tracing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
 
 func TestLoad(t *testing.T) {
 	err1 := Load("", "", "")
@@ -13,3 +27,134 @@ func TestLoad(t *testing.T) {
 		t.Error("variable baseURL must be set")
 	}
 }
+
+func TestDo(t *testing.T) {
+	defer setup()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/ok":
+			if r.Header.Get("ENCLAVE-SIGN") == "" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			w.Write([]byte(`{"success":true,"result":"hello"}`))
+		case "/empty":
+			w.WriteHeader(http.StatusNoContent)
+		case "/html":
+			w.WriteHeader(http.StatusBadGateway)
+			w.Write([]byte("<html><body>" + strings.Repeat("bad gateway ", 100) + "</body></html>"))
+		case "/limited":
+			w.Header().Set("Retry-After", "2")
+			w.WriteHeader(http.StatusTooManyRequests)
+		case "/funds":
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"success":false,"error":"not enough USDC","error_code":"insufficient_funds"}`))
+		case "/garbage":
+			w.Write([]byte("not json"))
+		}
+	}))
+	defer server.Close()
+	Load("key", "secret", server.URL)
+	ctx := context.Background()
+
+	ok := APIResponse[string]{}
+	if err := do(ctx, http.MethodGet, "/ok", nil, true, &ok); err != nil || ok.Result != "hello" {
+		t.Errorf("unexpected result: %v, %v", ok.Result, err)
+	}
+
+	if err := do(ctx, http.MethodGet, "/empty", nil, false, &APIResponse[string]{}); err != nil {
+		t.Errorf("expected nil for empty response, got: %v", err)
+	}
+
+	var apiErr *APIError
+	err := do(ctx, http.MethodGet, "/html", nil, false, &APIResponse[string]{})
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusBadGateway || len(apiErr.Message) > maxSnippetBytes+3 || !strings.HasPrefix(apiErr.Message, "<html>") {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	err = do(ctx, http.MethodGet, "/limited", nil, false, &APIResponse[string]{})
+	if !errors.Is(err, ErrRateLimited) || !errors.As(err, &apiErr) || apiErr.RetryAfter != 2*time.Second {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	err = do(ctx, http.MethodPost, "/funds", []byte(`{}`), true, &APIResponse[string]{})
+	if !errors.Is(err, ErrInsufficientFunds) || !errors.As(err, &apiErr) || apiErr.Message != "not enough USDC" {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	err = do(ctx, http.MethodGet, "/garbage", nil, false, &APIResponse[string]{})
+	if err == nil || errors.As(err, &apiErr) || !strings.Contains(err.Error(), "not json") {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestRequestMetrics(t *testing.T) {
+	defer setup()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	}))
+	defer server.Close()
+	LoadPublic(server.URL)
+
+	// Other tests also request orders, so only this test's status code has a known count
+	requests := requestsTotal.WithLabelValues("/v1/orders/{id}", http.MethodGet, "418")
+	sent := testutil.ToFloat64(requests)
+	do(context.Background(), http.MethodGet, "/v1/orders/metrics-a?x=1", nil, false, &APIResponse[string]{})
+	do(context.Background(), http.MethodGet, "/v1/orders/metrics-b", nil, false, &APIResponse[string]{})
+
+	if count := testutil.ToFloat64(requests) - sent; count != 2 {
+		t.Errorf("expected 2 requests counted for /v1/orders/{id}, got %v", count)
+	}
+	if count := testutil.CollectAndCount(requestDuration, "enclave_api_request_duration_seconds"); count == 0 {
+		t.Errorf("expected the request duration to be observed")
+	}
+}
+
+func TestRequestSpans(t *testing.T) {
+	defer setup()
+	recorder := tracetest.NewSpanRecorder()
+	tracing.Setup("test", recorder)
+	defer tracing.Shutdown(context.Background())
+
+	traceparent := ""
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceparent = r.Header.Get("traceparent")
+		w.Write([]byte(`{"success":true,"result":"hello"}`))
+	}))
+	defer server.Close()
+	Load("key", "secret", server.URL)
+
+	ctx, attempt := tracing.Start(context.Background(), "twap.attempt")
+	if err := do(ctx, http.MethodPost, "/v1/orders", []byte(`{}`), true, &APIResponse[string]{}); err != nil {
+		t.Fatal(err)
+	}
+	attempt.End()
+
+	spans := recorder.Ended()
+	names := []string{}
+	for _, span := range spans {
+		names = append(names, span.Name())
+		if span.SpanContext().TraceID().String() != tracing.TraceID(attempt) {
+			t.Errorf("%s isn't in the attempt's trace", span.Name())
+		}
+	}
+	if strings.Join(names, ",") != "rate_limit.wait,sign,HTTP POST,api.request,twap.attempt" {
+		t.Fatalf("unexpected spans: %v", names)
+	}
+	request := spans[2]
+	if request.SpanKind() != trace.SpanKindClient || request.Parent().SpanID() != spans[3].SpanContext().SpanID() || spans[3].Parent().SpanID() != spans[4].SpanContext().SpanID() {
+		t.Errorf("unexpected request span: %+v", request)
+	}
+	status := false
+	for _, attribute := range request.Attributes() {
+		status = status || (attribute.Key == "http.response.status_code" && attribute.Value.AsInt64() == http.StatusOK)
+	}
+	if !status {
+		t.Errorf("expected the response status on the request span, got: %v", request.Attributes())
+	}
+	if expected := "00-" + request.SpanContext().TraceID().String() + "-" + request.SpanContext().SpanID().String() + "-01"; traceparent != expected {
+		t.Errorf("expected traceparent %s, got %s", expected, traceparent)
+	}
+}
@@ -0,0 +1,71 @@
+package api
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// A token bucket shared by every request to the API, the same kind of limiter the Enclave API enforces
+type rateLimiter struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+var limiter = &rateLimiter{}
+
+// Limits requests to perSecond on average with bursts of up to burst requests. A rate of 0 disables the limit
+func SetRateLimit(perSecond float64, burst int) {
+	if burst < 1 {
+		burst = 1
+	}
+	limiter.mu.Lock()
+	defer limiter.mu.Unlock()
+	limiter.rate = perSecond
+	limiter.burst = float64(burst)
+	limiter.tokens = float64(burst)
+	limiter.last = time.Now()
+}
+
+// Blocks until a request may be sent or the context is canceled
+func (l *rateLimiter) wait(ctx context.Context) error {
+	start := time.Now()
+	for waited := false; ; waited = true {
+		wait := l.reserve()
+		if wait == 0 {
+			if waited {
+				rateLimitWaits.Inc()
+				rateLimitWaitSeconds.Add(time.Since(start).Seconds())
+			}
+			return nil
+		}
+		if err := Sleep(ctx, wait); err != nil {
+			return err
+		}
+	}
+}
+
+// Takes a token if one is available, otherwise returns how long until one will be
+func (l *rateLimiter) reserve() time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.rate <= 0 {
+		return 0
+	}
+
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.last = now
+
+	if l.tokens >= 1 {
+		l.tokens--
+		return 0
+	}
+	return time.Duration((1 - l.tokens) / l.rate * float64(time.Second))
+}
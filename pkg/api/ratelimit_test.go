@@ -0,0 +1,36 @@
+package api
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	defer SetRateLimit(0, 0)
+
+	SetRateLimit(20, 2)
+	start := time.Now()
+	for i := 0; i < 4; i++ {
+		if err := limiter.wait(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The burst of 2 is immediate and the other 2 wait 50ms each
+	if elapsed := time.Since(start); elapsed < 90*time.Millisecond || elapsed > 500*time.Millisecond {
+		t.Errorf("expected ~100ms for 4 requests, got: %s", elapsed)
+	}
+
+	SetRateLimit(0.1, 1)
+	limiter.wait(context.Background())
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := limiter.wait(ctx); err == nil {
+		t.Errorf("expected canceled context to stop waiting")
+	}
+
+	SetRateLimit(0, 0)
+	if wait := limiter.reserve(); wait != 0 {
+		t.Errorf("expected no limit, got: %s", wait)
+	}
+}
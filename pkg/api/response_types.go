@@ -43,6 +43,25 @@ type GetMarketsResponse struct {
 	SpotMarkets  SpotMarkets  `json:"spot"`
 }
 
+//region Book
+
+type GetBookResponse struct {
+	Market string     `json:"market"`
+	Asks   [][]string `json:"asks"`
+	Bids   [][]string `json:"bids"`
+	Time   string     `json:"time"`
+}
+
+//region Trades
+
+type GetTradesResponse struct {
+	Market string `json:"market"`
+	Price  string `json:"price"`
+	Size   string `json:"size"`
+	Side   string `json:"side"`
+	Time   string `json:"time"`
+}
+
 //region Balance
 
 type GetBalanceResponse struct {
@@ -83,3 +102,11 @@ type CreateSpotOrderResponse struct {
 	TimeInForce   string `json:"timeInForce"`
 	CancelReason  string `json:"cancelReason"`
 }
+
+//region Orders
+
+type GetOrdersResponse struct {
+	Orders []CreateSpotOrderResponse `json:"orders"`
+	// Set when there are more orders than fit in one page
+	NextCursor string `json:"nextCursor,omitempty"`
+}
@@ -0,0 +1,191 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math"
+	"math/rand"
+	"net"
+	"net/http"
+	"time"
+)
+
+// How an error should be treated by a retry loop
+type ErrorClass int
+
+const (
+	// The request can't succeed by retrying it, e.g. bad credentials or an unknown market
+	FATAL ErrorClass = iota
+	// A temporary failure such as a network blip, a 5xx or rate limiting. Safe to retry without counting as a failure
+	TRANSIENT
+	// An error that isn't known to be either, retried but counted as a failure
+	RETRYABLE
+)
+
+func (c ErrorClass) String() string {
+	switch c {
+	case FATAL:
+		return "fatal"
+	case TRANSIENT:
+		return "transient"
+	}
+	return "retryable"
+}
+
+// Classifies an error returned by this package
+func Classify(err error) ErrorClass {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		// The caller gave up, a timeout on a single request surfaces as a net.Error instead
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			return TRANSIENT
+		}
+		return FATAL
+	}
+
+	if errors.Is(err, ErrAuth) || errors.Is(err, ErrMarketNotFound) || errors.Is(err, ErrInvalidSize) || errors.Is(err, ErrInsufficientFunds) || errors.Is(err, ErrTimestampExpired) || errors.Is(err, ErrPermissionDenied) {
+		return FATAL
+	}
+	if errors.Is(err, ErrRateLimited) {
+		return TRANSIENT
+	}
+
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		if apiErr.StatusCode >= http.StatusInternalServerError || apiErr.StatusCode == http.StatusRequestTimeout {
+			return TRANSIENT
+		}
+		return RETRYABLE
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF) {
+		return TRANSIENT
+	}
+	return RETRYABLE
+}
+
+// Whether a failed request may still have been carried out by the exchange, so it has to be reconciled before it is sent
+// again rather than retried blindly. Only an error response other than a 5xx or 408 is a definite rejection. Timeouts,
+// dropped connections, 5xxs and responses that couldn't be read all leave it unknown
+func Ambiguous(err error) bool {
+	if err == nil {
+		return false
+	}
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.StatusCode >= http.StatusInternalServerError || apiErr.StatusCode == http.StatusRequestTimeout
+	}
+	return true
+}
+
+// Exponential backoff settings for retrying requests
+type RetryPolicy struct {
+	// Total attempts including the first, 0 means no limit
+	MaxAttempts int
+	// Time since the first attempt after which no more retries are made, 0 means no limit
+	MaxElapsed     time.Duration
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	Multiplier     float64
+	// Fraction the backoff is randomly varied by in either direction, e.g 0.2 for +/-20%
+	Jitter float64
+}
+
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts:    5,
+		MaxElapsed:     30 * time.Second,
+		InitialBackoff: 200 * time.Millisecond,
+		MaxBackoff:     5 * time.Second,
+		Multiplier:     2,
+		Jitter:         0.2,
+	}
+}
+
+// Tracks the attempts of a single operation against a RetryPolicy
+type Retrier struct {
+	policy   RetryPolicy
+	attempts int
+	start    time.Time
+}
+
+func NewRetrier(policy RetryPolicy) *Retrier {
+	return &Retrier{policy: policy, start: time.Now()}
+}
+
+// Records a failed attempt and returns how long to wait before the next one, or false if the error is fatal or the
+// policy is exhausted. A Retry-After from a rate limited response is used instead of the backoff
+func (r *Retrier) Next(err error) (time.Duration, bool) {
+	r.attempts++
+	if Classify(err) == FATAL {
+		return 0, false
+	}
+	if r.policy.MaxAttempts > 0 && r.attempts >= r.policy.MaxAttempts {
+		return 0, false
+	}
+
+	wait := r.backoff()
+	var apiErr *APIError
+	if errors.As(err, &apiErr) && apiErr.RetryAfter > 0 {
+		wait = apiErr.RetryAfter
+	}
+
+	if r.policy.MaxElapsed > 0 && time.Since(r.start)+wait > r.policy.MaxElapsed {
+		return 0, false
+	}
+	retriesTotal.WithLabelValues(Classify(err).String()).Inc()
+	return wait, true
+}
+
+// The number of failed attempts recorded so far
+func (r *Retrier) Attempts() int {
+	return r.attempts
+}
+
+func (r *Retrier) backoff() time.Duration {
+	multiplier := r.policy.Multiplier
+	if multiplier < 1 {
+		multiplier = 1
+	}
+	backoff := float64(r.policy.InitialBackoff) * math.Pow(multiplier, float64(r.attempts-1))
+	if r.policy.MaxBackoff > 0 && backoff > float64(r.policy.MaxBackoff) {
+		backoff = float64(r.policy.MaxBackoff)
+	}
+	if r.policy.Jitter > 0 {
+		backoff += backoff * r.policy.Jitter * (2*rand.Float64() - 1)
+	}
+	return time.Duration(backoff)
+}
+
+// Calls fn until it succeeds, returns a fatal error, the policy is exhausted or the context is canceled. Returns the
+// last error
+func Retry(ctx context.Context, policy RetryPolicy, fn func(ctx context.Context) error) error {
+	retrier := NewRetrier(policy)
+	for {
+		err := fn(ctx)
+		if err == nil {
+			return nil
+		}
+		wait, ok := retrier.Next(err)
+		if !ok {
+			return err
+		}
+		if err := Sleep(ctx, wait); err != nil {
+			return err
+		}
+	}
+}
+
+// Sleeps for the duration or until the context is canceled, returning the context error if it was
+func Sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,121 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestClassify(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		expected ErrorClass
+	}{
+		{name: "Auth", err: &APIError{StatusCode: 401}, expected: FATAL},
+		{name: "Market", err: fmt.Errorf("%w: ABC-DEF", ErrMarketNotFound), expected: FATAL},
+		{name: "Size", err: &APIError{StatusCode: 400, Code: "invalid_size"}, expected: FATAL},
+		{name: "Funds", err: &APIError{StatusCode: 400, Code: "insufficient_funds"}, expected: FATAL},
+		{name: "Canceled", err: context.Canceled, expected: FATAL},
+		{name: "Rate limited", err: &APIError{StatusCode: 429}, expected: TRANSIENT},
+		{name: "Bad gateway", err: &APIError{StatusCode: 502}, expected: TRANSIENT},
+		{name: "Network", err: &net.OpError{Op: "dial", Err: errors.New("connection refused")}, expected: TRANSIENT},
+		{name: "EOF", err: io.ErrUnexpectedEOF, expected: TRANSIENT},
+		{name: "Unknown code", err: &APIError{StatusCode: 400, Code: "something_else"}, expected: RETRYABLE},
+		{name: "Unknown", err: errors.New("unknown"), expected: RETRYABLE},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if class := Classify(tt.err); class != tt.expected {
+				t.Errorf("expected %s, got: %s", tt.expected, class)
+			}
+		})
+	}
+}
+
+func TestAmbiguous(t *testing.T) {
+	ambiguous := []error{&APIError{StatusCode: 502}, &APIError{StatusCode: 408}, io.ErrUnexpectedEOF, context.DeadlineExceeded, &net.OpError{Op: "read", Err: errors.New("connection reset")}}
+	for _, err := range ambiguous {
+		if !Ambiguous(err) {
+			t.Errorf("expected %v to be ambiguous", err)
+		}
+	}
+	rejected := []error{nil, &APIError{StatusCode: 400, Code: "insufficient_funds"}, &APIError{StatusCode: 429}, &APIError{StatusCode: 200, Code: "invalid_size"}}
+	for _, err := range rejected {
+		if Ambiguous(err) {
+			t.Errorf("expected %v to be a definite outcome", err)
+		}
+	}
+}
+
+func TestRetrierNext(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 3, InitialBackoff: 100 * time.Millisecond, MaxBackoff: 150 * time.Millisecond, Multiplier: 2}
+	retrier := NewRetrier(policy)
+
+	wait, ok := retrier.Next(errors.New("unknown"))
+	if !ok || wait != 100*time.Millisecond {
+		t.Errorf("expected 100ms, got: %s, %v", wait, ok)
+	}
+	wait, ok = retrier.Next(&APIError{StatusCode: http.StatusTooManyRequests, RetryAfter: time.Second})
+	if !ok || wait != time.Second {
+		t.Errorf("expected Retry-After of 1s, got: %s, %v", wait, ok)
+	}
+	if _, ok = retrier.Next(errors.New("unknown")); ok || retrier.Attempts() != 3 {
+		t.Errorf("expected attempts to be exhausted after 3, got: %d", retrier.Attempts())
+	}
+
+	if _, ok := NewRetrier(policy).Next(ErrAuth); ok {
+		t.Errorf("expected fatal error not to be retried")
+	}
+
+	capped := NewRetrier(RetryPolicy{InitialBackoff: time.Second, MaxBackoff: 2 * time.Second, Multiplier: 10})
+	capped.Next(errors.New("unknown"))
+	if wait, _ := capped.Next(errors.New("unknown")); wait != 2*time.Second {
+		t.Errorf("expected backoff capped at 2s, got: %s", wait)
+	}
+
+	elapsed := NewRetrier(RetryPolicy{InitialBackoff: time.Second, MaxElapsed: 500 * time.Millisecond})
+	if _, ok := elapsed.Next(errors.New("unknown")); ok {
+		t.Errorf("expected max elapsed to stop retries")
+	}
+}
+
+func TestRetry(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 5, InitialBackoff: time.Millisecond, Multiplier: 1}
+
+	calls := 0
+	err := Retry(context.Background(), policy, func(ctx context.Context) error {
+		calls++
+		if calls < 3 {
+			return &APIError{StatusCode: http.StatusBadGateway}
+		}
+		return nil
+	})
+	if err != nil || calls != 3 {
+		t.Errorf("expected success after 3 calls, got: %v after %d", err, calls)
+	}
+
+	calls = 0
+	err = Retry(context.Background(), policy, func(ctx context.Context) error {
+		calls++
+		return ErrMarketNotFound
+	})
+	if !errors.Is(err, ErrMarketNotFound) || calls != 1 {
+		t.Errorf("expected fatal error after 1 call, got: %v after %d", err, calls)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = Retry(ctx, policy, func(ctx context.Context) error {
+		return errors.New("unknown")
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context canceled, got: %v", err)
+	}
+}
@@ -7,6 +7,13 @@ const (
 	SELL Side = "sell"
 )
 
+type Denomination string
+
+const (
+	BASE  Denomination = "base"
+	QUOTE Denomination = "quote"
+)
+
 type OrderType string
 
 const (
@@ -21,6 +28,14 @@ const (
 	IOC TimeInForce = "IOC"
 )
 
+// Which orders to list
+type OrderStatus string
+
+const (
+	OPEN_ORDERS   OrderStatus = "open"
+	CLOSED_ORDERS OrderStatus = "closed"
+)
+
 type SpotOrderRequest struct {
 	ClientOrderId string      `json:"clientOrderId,omitempty"`
 	Market        string      `json:"market"`
@@ -32,3 +47,21 @@ type SpotOrderRequest struct {
 	TimeInForce   TimeInForce `json:"timeInForce,omitempty"`
 	PostOnly      bool        `json:"postOnly,omitempty"`
 }
+
+// What an API key is allowed to do, see GetKeyPermissions
+type KeyPermissions struct {
+	Read  bool
+	Trade bool
+}
+
+// Names of the permissions the key is missing
+func (p KeyPermissions) Missing() []string {
+	missing := []string{}
+	if !p.Read {
+		missing = append(missing, "read")
+	}
+	if !p.Trade {
+		missing = append(missing, "trade")
+	}
+	return missing
+}
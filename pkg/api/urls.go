@@ -0,0 +1,96 @@
+package api
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+const (
+	ProductionBaseURL = "https://api.enclave.market"
+	StagingBaseURL    = "https://api-staging.enclavemarket.dev"
+	SandboxBaseURL    = "https://api-sandbox.enclave.market"
+)
+
+// The base URLs allowed when no allow-list is configured
+func DefaultAllowedBaseURLs() []string {
+	return []string{ProductionBaseURL, StagingBaseURL, SandboxBaseURL}
+}
+
+// Returns an error if the base URL isn't an absolute http(s) URL with no path beyond /
+func CheckBaseURL(baseURL string) error {
+	parsed, err := url.Parse(baseURL)
+	if err != nil || (parsed.Scheme != "https" && parsed.Scheme != "http") || parsed.Host == "" {
+		return fmt.Errorf("base-url must be an absolute http or https URL, received: %s", baseURL)
+	}
+	if strings.Trim(parsed.Path, "/") != "" || parsed.RawQuery != "" || parsed.Fragment != "" {
+		return fmt.Errorf("base-url must not include a path, query or fragment, received: %s", baseURL)
+	}
+	return nil
+}
+
+// Returns an error if the base URL isn't in the allow-list. The default allow-list is used if allowed is empty
+func ValidateBaseURL(baseURL string, allowed []string) error {
+	if err := CheckBaseURL(baseURL); err != nil {
+		return err
+	}
+	if len(allowed) == 0 {
+		allowed = DefaultAllowedBaseURLs()
+	}
+	for _, candidate := range allowed {
+		if sameBaseURL(baseURL, candidate) {
+			return nil
+		}
+	}
+	return fmt.Errorf("base-url must be one of %s, received: %s", strings.Join(allowed, ", "), baseURL)
+}
+
+// Whether the base URL points at the production API, where orders trade real funds. Only the host is compared, so any
+// scheme, port or path that reaches it counts
+func IsProduction(baseURL string) bool {
+	production, _ := url.Parse(ProductionBaseURL)
+	return host(baseURL) == production.Hostname()
+}
+
+// The lower case host of the URL without a port or trailing dot, empty if it can't be parsed
+func host(baseURL string) string {
+	parsed, err := url.Parse(strings.TrimSpace(baseURL))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSuffix(strings.ToLower(parsed.Hostname()), ".")
+}
+
+// Compares base URLs ignoring case in the scheme and host, a trailing dot on the host, the scheme's default port and a
+// trailing slash
+func sameBaseURL(a, b string) bool {
+	normalise := func(s string) string {
+		parsed, err := url.Parse(strings.TrimSpace(s))
+		if err != nil {
+			return strings.ToLower(strings.TrimRight(strings.TrimSpace(s), "/"))
+		}
+		scheme, port := strings.ToLower(parsed.Scheme), parsed.Port()
+		if (scheme == "https" && port == "443") || (scheme == "http" && port == "80") {
+			port = ""
+		}
+		hostPort := host(s)
+		if port != "" {
+			hostPort += ":" + port
+		}
+		return scheme + "://" + hostPort + strings.TrimRight(parsed.EscapedPath(), "/")
+	}
+	return normalise(a) == normalise(b)
+}
+
+// The name of the Enclave environment the base URL points at, or custom for anything else
+func Environment(baseURL string) string {
+	switch {
+	case IsProduction(baseURL):
+		return "production"
+	case sameBaseURL(baseURL, StagingBaseURL):
+		return "staging"
+	case sameBaseURL(baseURL, SandboxBaseURL):
+		return "sandbox"
+	}
+	return "custom"
+}
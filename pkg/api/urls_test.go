@@ -0,0 +1,55 @@
+package api
+
+import "testing"
+
+func TestValidateBaseURL(t *testing.T) {
+	tests := []struct {
+		name      string
+		baseURL   string
+		allowed   []string
+		expectErr bool
+	}{
+		{"Default production", ProductionBaseURL, nil, false},
+		{"Default sandbox with trailing slash", SandboxBaseURL + "/", nil, false},
+		{"Not in defaults", "https://invalid-url.com", nil, true},
+		{"Local mock allowed", "http://localhost:8080", []string{"http://localhost:8080"}, false},
+		{"Configured list replaces defaults", SandboxBaseURL, []string{"http://localhost:8080"}, true},
+		{"No scheme", "api.enclave.market", nil, true},
+		{"Unsupported scheme", "ftp://api.enclave.market", []string{"ftp://api.enclave.market"}, true},
+		{"Path", "https://api.enclave.market/v1", []string{"https://api.enclave.market/v1"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateBaseURL(tt.baseURL, tt.allowed)
+			if (err != nil) != tt.expectErr {
+				t.Errorf("expected error: %v, got: %v", tt.expectErr, err)
+			}
+		})
+	}
+}
+
+func TestIsProduction(t *testing.T) {
+	for _, url := range []string{"https://API.enclave.market/", "https://api.enclave.market:443", "https://api.enclave.market.", "http://api.enclave.market", " HTTPS://Api.Enclave.Market// "} {
+		if !IsProduction(url) {
+			t.Errorf("expected production url %q to be detected", url)
+		}
+	}
+	if IsProduction(SandboxBaseURL) || IsProduction("http://localhost:8080") || IsProduction("https://api.enclave.market.evil.com") {
+		t.Errorf("expected non production urls not to be detected")
+	}
+}
+
+func TestEnvironment(t *testing.T) {
+	expected := map[string]string{
+		ProductionBaseURL:       "production",
+		StagingBaseURL + "/":    "staging",
+		SandboxBaseURL:          "sandbox",
+		"http://localhost:8080": "custom",
+	}
+	for baseURL, environment := range expected {
+		if got := Environment(baseURL); got != environment {
+			t.Errorf("expected %s for %s, got: %s", environment, baseURL, got)
+		}
+	}
+}
@@ -0,0 +1,174 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/garry-sharp/enclave-assessment/pkg/api"
+
+	"github.com/spf13/cobra"
+)
+
+const requestTimeout = 30 * time.Second
+
+var orderHeader = []string{"order_id", "client_order_id", "market", "side", "type", "status", "size", "price", "filled_size", "filled_cost", "fee", "created_at", "filled_at"}
+
+func orderRow(o api.CreateSpotOrderResponse) []string {
+	return []string{o.OrderId, o.ClientOrderId, o.Market, o.Side, o.Type, o.Status, o.Size, o.Price, o.FilledSize, o.FilledCost, o.Fee, o.CreatedAt, o.FilledAt}
+}
+
+// The commands for checking the account without placing orders
+func getAccountCommands(options *rootOptions) []*cobra.Command {
+	return []*cobra.Command{
+		getBalancesCommand(options),
+		getMarketsCommand(options),
+		getOrdersCommand(options),
+		getOrderCommand(options),
+	}
+}
+
+func getBalancesCommand(options *rootOptions) *cobra.Command {
+	connection := &connectionOptions{}
+	balancesCmd := &cobra.Command{
+		Use:   "balances",
+		Short: "List wallet balances",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := connection.connect(true); err != nil {
+				return err
+			}
+			defer connection.close()
+			ctx, cancel := context.WithTimeout(cmd.Context(), requestTimeout)
+			defer cancel()
+			response := api.APIResponse[[]api.GetBalancesResponse]{}
+			if err := api.GetBalances(ctx, &response); err != nil {
+				return err
+			}
+
+			t := table{header: []string{"coin", "free", "reserved", "total", "usd_value"}}
+			for _, b := range response.Result {
+				t.rows = append(t.rows, []string{b.Coin, b.Free, b.Reserved, b.Total, b.UsdValue})
+			}
+			return writeOutput(cmd.OutOrStdout(), options.output, response.Result, t)
+		},
+	}
+	connection.addFlags(balancesCmd.Flags())
+	return balancesCmd
+}
+
+func getMarketsCommand(options *rootOptions) *cobra.Command {
+	connection := &connectionOptions{}
+	var spot, cross bool
+	marketsCmd := &cobra.Command{
+		Use:   "markets",
+		Short: "List spot and cross markets, no API key is needed",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := connection.connect(false); err != nil {
+				return err
+			}
+			defer connection.close()
+			ctx, cancel := context.WithTimeout(cmd.Context(), requestTimeout)
+			defer cancel()
+			response := api.APIResponse[api.GetMarketsResponse]{}
+			if err := api.GetMarkets(ctx, &response); err != nil {
+				return err
+			}
+
+			// Both are listed unless one is picked
+			if !spot && !cross {
+				spot, cross = true, true
+			}
+			result := api.GetMarketsResponse{}
+			t := table{header: []string{"type", "market", "disabled", "base_increment", "quote_increment", "decimal_places"}}
+			if spot {
+				result.SpotMarkets = response.Result.SpotMarkets
+				for _, m := range result.SpotMarkets.TradingPairs {
+					t.rows = append(t.rows, []string{"spot", m.Pair.Base + "-" + m.Pair.Quote, strconv.FormatBool(m.Disabled), m.BaseIncrement, m.QuoteIncrement, ""})
+				}
+			}
+			if cross {
+				result.CrossMarkets = response.Result.CrossMarkets
+				for _, m := range result.CrossMarkets.TradingPairs {
+					t.rows = append(t.rows, []string{"cross", m.Pair.Base + "-" + m.Pair.Quote, strconv.FormatBool(m.Disabled), "", "", strconv.Itoa(m.DecimalPlaces)})
+				}
+			}
+			return writeOutput(cmd.OutOrStdout(), options.output, result, t)
+		},
+	}
+	marketsCmd.Flags().BoolVar(&spot, "spot", false, "Only list spot markets")
+	marketsCmd.Flags().BoolVar(&cross, "cross", false, "Only list cross markets")
+	marketsCmd.MarkFlagsMutuallyExclusive("spot", "cross")
+	connection.addFlags(marketsCmd.Flags())
+	return marketsCmd
+}
+
+func getOrdersCommand(options *rootOptions) *cobra.Command {
+	connection := &connectionOptions{}
+	var open, history bool
+	ordersCmd := &cobra.Command{
+		Use:   "orders",
+		Short: "List spot orders, open orders unless --history is set",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := connection.connect(true); err != nil {
+				return err
+			}
+			defer connection.close()
+			status := api.OPEN_ORDERS
+			if history {
+				status = api.CLOSED_ORDERS
+			}
+
+			ctx, cancel := context.WithTimeout(cmd.Context(), requestTimeout)
+			defer cancel()
+			orders, err := api.GetAllOrders(ctx, status)
+			if err != nil {
+				return err
+			}
+
+			t := table{header: orderHeader}
+			for _, o := range orders {
+				t.rows = append(t.rows, orderRow(o))
+			}
+			return writeOutput(cmd.OutOrStdout(), options.output, orders, t)
+		},
+	}
+	ordersCmd.Flags().BoolVar(&open, "open", false, "List open orders, the default")
+	ordersCmd.Flags().BoolVar(&history, "history", false, "List filled and canceled orders")
+	ordersCmd.MarkFlagsMutuallyExclusive("open", "history")
+	connection.addFlags(ordersCmd.Flags())
+	return ordersCmd
+}
+
+func getOrderCommand(options *rootOptions) *cobra.Command {
+	connection := &connectionOptions{}
+	getCmd := &cobra.Command{
+		Use:   "get <order-id>",
+		Short: "Show a single spot order",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := connection.connect(true); err != nil {
+				return err
+			}
+			defer connection.close()
+			ctx, cancel := context.WithTimeout(cmd.Context(), requestTimeout)
+			defer cancel()
+			response := api.APIResponse[api.CreateSpotOrderResponse]{}
+			if err := api.GetOrder(ctx, args[0], &response); err != nil {
+				return fmt.Errorf("unable to get order %s: %w", args[0], err)
+			}
+			return writeOutput(cmd.OutOrStdout(), options.output, response.Result, table{header: orderHeader, rows: [][]string{orderRow(response.Result)}})
+		},
+	}
+	connection.addFlags(getCmd.Flags())
+
+	orderCmd := &cobra.Command{
+		Use:   "order",
+		Short: "Inspect spot orders",
+	}
+	orderCmd.AddCommand(getCmd)
+	return orderCmd
+}
@@ -0,0 +1,57 @@
+package cli
+
+import (
+	"strconv"
+
+	"github.com/garry-sharp/enclave-assessment/pkg/api"
+
+	"github.com/spf13/cobra"
+)
+
+// The result of verifying an audit log
+type auditVerification struct {
+	Path     string `json:"path"`
+	Records  int64  `json:"records"`
+	LastHash string `json:"lastHash"`
+}
+
+func getAuditCommand(options *rootOptions) *cobra.Command {
+	var auditLog string
+
+	auditCmd := &cobra.Command{
+		Use:   "audit",
+		Short: "Inspect the audit log of signed requests",
+	}
+
+	verifyCmd := &cobra.Command{
+		Use:   "verify",
+		Short: "Check the audit log's hash chain, fails if any record was edited, removed, reordered or inserted",
+		Long: `Check the audit log's hash chain, fails if any record was edited, removed, reordered or inserted.
+Records removed from the end can only be detected by comparing the last hash to one kept from an earlier verify`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			records, lastHash, err := api.VerifyAuditLog(auditLog)
+			if err != nil {
+				return err
+			}
+			result := auditVerification{Path: auditLog, Records: records, LastHash: lastHash}
+			return writeOutput(cmd.OutOrStdout(), options.output, result, table{
+				header: []string{"path", "records", "last_hash"},
+				rows:   [][]string{{result.Path, strconv.FormatInt(result.Records, 10), result.LastHash}},
+			})
+		},
+	}
+	verifyCmd.Flags().StringVar(&auditLog, "audit-log", defaultAuditLog(), "The audit log to verify")
+	bindEnv(verifyCmd.Flags(), map[string]string{"audit-log": "AUDIT_LOG"})
+
+	auditCmd.AddCommand(verifyCmd)
+	return auditCmd
+}
+
+func defaultAuditLog() string {
+	path, err := api.DefaultAuditLogPath()
+	if err != nil {
+		return "audit.jsonl"
+	}
+	return path
+}
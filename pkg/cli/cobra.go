@@ -1,8 +1,11 @@
 package cli
 
 import (
+	"errors"
+	"fmt"
 	"log"
 	"os"
+	"path/filepath"
 
 	"github.com/garry-sharp/enclave-assessment/pkg/twap"
 
@@ -12,16 +15,35 @@ import (
 	"github.com/spf13/cobra"
 )
 
-func getTwapCommand() *cobra.Command {
+func getTwapCommand(options *rootOptions) *cobra.Command {
+	connection := &connectionOptions{}
 	var (
-		side      string
-		amount    string
-		duration  string
-		market    string
-		interval  string
-		apiKey    string
-		apiSecret string
-		baseURL   string
+		side          string
+		amount        string
+		duration      string
+		market        string
+		interval      string
+		denomination  string
+		minPrice      string
+		maxPrice      string
+		extension     string
+		feeReserve    string
+		balancePolicy string
+		balanceWait   string
+		maxNotional   string
+		maxSlice      string
+		maxDaily      string
+		markets       []string
+		sides         []string
+		riskLedger    string
+		reportPath    string
+		runLogDir     string
+		notifyURL     string
+		notifyFormat  string
+		notifySecret  string
+		tui           bool
+		yes           bool
+		confirmProd   bool
 	)
 
 	var twapCmd = &cobra.Command{
@@ -34,22 +56,195 @@ _______         ___    ____
   | |   \ V  V / ___ \|  __/ 
   |_|    \_/\_/_/   \_\_|`,
 		Run: func(cmd *cobra.Command, args []string) {
-			err := twap.ExecuteTwap(side, amount, duration, market, interval, apiKey, apiSecret, baseURL)
+			// In JSON mode stdout is a stream of events ending with a result or error line, see twapStream
+			var stream *twapStream
+			var events func(twap.Event)
+			if options.output == jsonOutput {
+				stream = newTwapStream(os.Stdout)
+				events = stream.event
+			}
+			// The dashboard is drawn over stdout, so plain logs are kept when it isn't a terminal e.g. piped to a file
+			var dash *dashboard
+			fail := func(message string, err error) {
+				if dash != nil {
+					dash.stop()
+				}
+				logger.Error(message, logger.ErrorKey, err)
+				if stream != nil {
+					stream.error(err)
+				}
+				exit(1)
+			}
+
+			if tui {
+				switch {
+				case options.output == jsonOutput:
+					fail("Failed to execute TWAP trade", fmt.Errorf("--tui can't be used with --output json"))
+				case options.trace == stdoutTrace:
+					fail("Failed to execute TWAP trade", fmt.Errorf("--tui can't be used with --trace stdout, use --trace otlp instead"))
+				default:
+					if dash = newTerminalDashboard(); dash != nil {
+						events = dash.event
+					} else {
+						logger.Info("stdout is not a terminal, logging progress instead of showing the dashboard")
+					}
+				}
+			}
+
+			runID := twap.NewRunID()
+			if runLogDir != "" {
+				path, err := startRunLog(runLogDir, runID, options.logOptions)
+				if err != nil {
+					fail("Failed to open the run log", err)
+				}
+				logger.Info("Writing run log", logger.RunIDKey, runID, "path", path)
+			}
+
+			if reportPath != "" {
+				if _, err := twap.ReportFormat(reportPath); err != nil {
+					fail("Failed to execute TWAP trade", err)
+				}
+			}
+
+			var notifier twap.Notifier
+			if notifyURL != "" {
+				webhook, err := twap.NewWebhookNotifier(notifyURL, notifyFormat, notifySecret)
+				if err != nil {
+					fail("Failed to execute TWAP trade", err)
+				}
+				notifier = webhook
+			}
+
+			if err := confirmEnvironment(connection.baseURL, confirmProd); err != nil {
+				fail("Failed to execute TWAP trade", err)
+			}
+			if err := connection.resolveCredentials(); err != nil {
+				fail("Failed to execute TWAP trade", err)
+			}
+			if err := connection.startAudit(); err != nil {
+				fail("Failed to execute TWAP trade", err)
+			}
+			defer connection.close()
+
+			report, runErr := twap.ExecuteTwap(twap.TwapArgs{
+				RunID:            runID,
+				Side:             side,
+				Amount:           amount,
+				Duration:         duration,
+				Market:           market,
+				Interval:         interval,
+				Denomination:     denomination,
+				MinPrice:         minPrice,
+				MaxPrice:         maxPrice,
+				Extension:        extension,
+				FeeReserve:       feeReserve,
+				BalancePolicy:    balancePolicy,
+				BalanceWait:      balanceWait,
+				MaxNotional:      maxNotional,
+				MaxSliceNotional: maxSlice,
+				MaxDailyNotional: maxDaily,
+				AllowedMarkets:   markets,
+				AllowedSides:     sides,
+				RiskLedger:       riskLedger,
+				APIKey:           connection.apiKey,
+				APISecret:        connection.apiSecret,
+				BaseURL:          connection.baseURL,
+				AllowedBaseURLs:  connection.allowedURLs,
+				AllowAnyBaseURL:  connection.allowAnyURL,
+				Confirm: func(plan *twap.Plan) error {
+					return confirmPlan(plan, yes)
+				},
+				Events:   events,
+				Notifier: notifier,
+			})
+			if dash != nil {
+				dash.stop()
+			}
+			// An aborted run still has a report of what was filled, it is written before exiting with the error
+			if runErr != nil && (report == nil || !errors.Is(runErr, twap.ErrAborted)) {
+				fail("Failed to execute TWAP trade", runErr)
+			}
+
+			var err error
+			switch options.output {
+			case jsonOutput:
+				err = stream.result(report, runErr)
+			case csvOutput:
+				err = report.WriteCSV(os.Stdout)
+			default:
+				err = report.WriteTable(os.Stdout)
+			}
 			if err != nil {
-				logger.Error("Failed to execute TWAP trade", err)
-				os.Exit(1)
+				logger.Error("Failed to write TWAP report", logger.ErrorKey, err)
+				exit(1)
+			}
+			if reportPath != "" {
+				if err := report.Export(reportPath); err != nil {
+					logger.Error("Failed to export TWAP report", logger.ErrorKey, err)
+					exit(1)
+				}
+				logger.Info("TWAP report written", "path", reportPath)
+			}
+			if runErr != nil {
+				logger.Error("TWAP aborted", logger.ErrorKey, runErr)
+				exit(1)
 			}
 		},
 	}
 
-	twapCmd.Flags().StringVarP(&side, "side", "s", getEnv("TRADE_SIDE", ""), "The side the trade should run on (buy or sell)")
-	twapCmd.Flags().StringVarP(&amount, "amount", "a", getEnv("AMOUNT", ""), "Amount to be bought or sold. Denominated in the quote currency if a buy and the base currency if a sell")
-	twapCmd.Flags().StringVarP(&duration, "duration", "d", getEnv("DURATION", ""), "The length of time the TWAP will take place over, expressed as a number and then a unit e.g 20m for twenty minutes\nValid time units are “ns”, “us” (or “µs”), “ms”, “s”, “m”, “h”")
-	twapCmd.Flags().StringVarP(&market, "market", "m", getEnv("MARKET", ""), "The market to run the trade on. Denominated in the base and quote currency separated by a hyphen e.g AVAX-USDC")
-	twapCmd.Flags().StringVarP(&interval, "interval", "i", getEnv("INTERVAL", ""), "How often the TWAP will run, this must divide perfectly into the duration, expressed as a number and then a unit e.g 30s for thirty seconds\nA maximum of 1000 intervals are allowed per execution\nValid time units are “ms”, “s”, “m”, “h”, 500ms is the smallest interval")
-	twapCmd.Flags().StringVar(&apiKey, "api-key", getEnv("API_KEY", ""), "The Enclave.markets API key")
-	twapCmd.Flags().StringVar(&apiSecret, "api-secret", getEnv("API_SECRET", ""), "The Enclave.markets API key")
-	twapCmd.Flags().StringVar(&baseURL, "base-url", getEnv("BASE_URL", "https://api-sandbox.enclave.market"), "The base url for the Enclave.markets API")
+	twapCmd.Flags().StringVarP(&side, "side", "s", "", "The side the trade should run on (buy or sell)")
+	twapCmd.Flags().StringVarP(&amount, "amount", "a", "", "Amount to be bought or sold. Denominated in the quote currency if a buy and the base currency if a sell unless --denomination is set")
+	twapCmd.Flags().StringVarP(&duration, "duration", "d", "", "The length of time the TWAP will take place over, expressed as a number and then a unit e.g 20m for twenty minutes\nValid time units are “ns”, “us” (or “µs”), “ms”, “s”, “m”, “h”")
+	twapCmd.Flags().StringVarP(&market, "market", "m", "", "The market to run the trade on. Denominated in the base and quote currency separated by a hyphen e.g AVAX-USDC")
+	twapCmd.Flags().StringVarP(&interval, "interval", "i", "", "How often the TWAP will run, this must divide perfectly into the duration, expressed as a number and then a unit e.g 30s for thirty seconds\nA maximum of 1000 intervals are allowed per execution\nValid time units are “ms”, “s”, “m”, “h”, 500ms is the smallest interval")
+	twapCmd.Flags().StringVar(&denomination, "denomination", "", "The currency the amount is denominated in (base or quote). Defaults to quote for a buy and base for a sell")
+	twapCmd.Flags().StringVar(&minPrice, "min-price", "", "Slices are deferred while the mid price is below this value")
+	twapCmd.Flags().StringVar(&maxPrice, "max-price", "", "Slices are deferred while the mid price is above this value")
+	twapCmd.Flags().StringVar(&extension, "extend", "", "How long the TWAP may run past its end time to place slices deferred by --min-price or --max-price, e.g 10m")
+	twapCmd.Flags().StringVar(&feeReserve, "fee-reserve", "", "Fraction of the amount to reserve for fees when checking the balance of a buy, e.g 0.001 for 0.1%")
+	twapCmd.Flags().StringVar(&balancePolicy, "balance-policy", "abort", "What to do if a slice is rejected for insufficient funds mid run (abort, shrink or wait)\nshrink spreads the remaining balance over the remaining slices, wait pauses until the balance is topped up")
+	twapCmd.Flags().StringVar(&balanceWait, "balance-wait", "5m", "How long the wait balance policy pauses for funds before aborting")
+	twapCmd.Flags().StringVar(&maxNotional, "max-notional", "", "Refuse runs with a notional in the quote currency larger than this")
+	twapCmd.Flags().StringVar(&maxSlice, "max-slice-notional", "", "Refuse slices with a notional in the quote currency larger than this, checked at the latest price before each slice")
+	twapCmd.Flags().StringVar(&maxDaily, "max-daily-notional", "", "Refuse to trade more than this notional in the quote currency per market and API key each UTC day, across runs")
+	twapCmd.Flags().StringSliceVar(&markets, "allowed-markets", nil, "Comma separated markets the TWAP may run on, any market if empty")
+	twapCmd.Flags().StringSliceVar(&sides, "allowed-sides", nil, "Comma separated sides the TWAP may run on (buy, sell), either if empty")
+	twapCmd.Flags().StringVar(&riskLedger, "risk-ledger", defaultRiskLedger(), "The file notional traded each day is recorded in for --max-daily-notional")
+	twapCmd.Flags().StringVar(&runLogDir, "run-log-dir", "", "Directory to also write this run's logs to, in a file named by its run ID e.g. 20240501T120000Z-1a2b3c4d.log")
+	twapCmd.Flags().StringVar(&notifyURL, "notify-url", "", "Webhook to post to when the TWAP starts, passes 25, 50 and 75% filled, has a slice fail, aborts and completes")
+	twapCmd.Flags().StringVar(&notifyFormat, "notify-format", twap.JSONWebhook, "The payload posted to --notify-url (json or slack)")
+	twapCmd.Flags().StringVar(&notifySecret, "notify-secret", "", "Signs the payloads posted to --notify-url with HMAC-SHA256, see the "+twap.WebhookSignatureHeader+" header")
+	twapCmd.Flags().BoolVar(&tui, "tui", false, "Show a live dashboard of the run's progress instead of logs on the terminal, logs are still written to the log file\nFalls back to logs when stdout isn't a terminal")
+	twapCmd.Flags().StringVar(&reportPath, "report", "", "File to export the execution report to once the TWAP completes, the format is taken from the extension (.json or .csv)")
+	connection.addFlags(twapCmd.Flags())
+	twapCmd.Flags().BoolVarP(&yes, "yes", "y", false, "Start without asking to confirm the plan, for automation")
+	twapCmd.Flags().BoolVar(&confirmProd, "confirm-production", false, "Start on production without typing production to confirm it, for automation. --yes doesn't skip it")
+	bindEnv(twapCmd.Flags(), map[string]string{
+		"side":               "TRADE_SIDE",
+		"amount":             "AMOUNT",
+		"duration":           "DURATION",
+		"market":             "MARKET",
+		"interval":           "INTERVAL",
+		"denomination":       "DENOMINATION",
+		"min-price":          "MIN_PRICE",
+		"max-price":          "MAX_PRICE",
+		"extend":             "EXTEND",
+		"fee-reserve":        "FEE_RESERVE",
+		"balance-policy":     "BALANCE_POLICY",
+		"balance-wait":       "BALANCE_WAIT",
+		"max-notional":       "MAX_NOTIONAL",
+		"max-slice-notional": "MAX_SLICE_NOTIONAL",
+		"max-daily-notional": "MAX_DAILY_NOTIONAL",
+		"allowed-markets":    "ALLOWED_MARKETS",
+		"allowed-sides":      "ALLOWED_SIDES",
+		"risk-ledger":        "RISK_LEDGER",
+		"report":             "REPORT",
+		"run-log-dir":        "RUN_LOG_DIR",
+		"notify-url":         "NOTIFY_URL",
+		"notify-format":      "NOTIFY_FORMAT",
+		"notify-secret":      "NOTIFY_SECRET",
+		"tui":                "TUI",
+	})
 	return twapCmd
 }
 
@@ -59,13 +254,44 @@ func LoadCLI() (*cobra.Command, error) {
 		log.Println("No .env file found, using default values")
 	}
 
-	return getTwapCommand(), nil
+	options := &rootOptions{}
+	rootCmd := &cobra.Command{
+		Use:          "enclave-twap",
+		Short:        "Trade on Enclave.markets with TWAP execution",
+		SilenceUsage: true,
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			return options.applyConfig(cmd)
+		},
+	}
+	options.addFlags(rootCmd)
+	rootCmd.AddCommand(getTwapCommand(options), getKeysCommand(options), getAuditCommand(options))
+	rootCmd.AddCommand(getAccountCommands(options)...)
+	return rootCmd, nil
+}
+
+// Copies every log from here on to <dir>/<run id>.log as well as the usual log file, returns the path
+func startRunLog(dir, runID string, opts logger.Options) (string, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, runID+".log")
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
+	if err != nil {
+		return "", err
+	}
+	l, err := logger.Tee(file, opts)
+	if err != nil {
+		file.Close()
+		return "", err
+	}
+	logger.SetLogger(l)
+	return path, nil
 }
 
-// Helper function to get environment variables with a fallback default
-func getEnv(key, defaultValue string) string {
-	if value, exists := os.LookupEnv(key); exists {
-		return value
+func defaultRiskLedger() string {
+	path, err := twap.DefaultRiskLedgerPath()
+	if err != nil {
+		return "risk-ledger.json"
 	}
-	return defaultValue
+	return path
 }
@@ -0,0 +1,317 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/garry-sharp/enclave-assessment/pkg/api"
+	"github.com/garry-sharp/enclave-assessment/pkg/config"
+	"github.com/garry-sharp/enclave-assessment/pkg/logger"
+	"github.com/garry-sharp/enclave-assessment/pkg/metrics"
+	"github.com/garry-sharp/enclave-assessment/pkg/tracing"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// The flag annotation holding the environment variable a flag is read from
+const envAnnotation = "env"
+
+// The file logs are written to once the logger is set up, closed by Shutdown
+var logFile *logger.RotatingFile
+
+// Binds flags to environment variables, keyed by flag name. The variable is applied by applyConfig if the flag isn't set
+func bindEnv(flags *pflag.FlagSet, envs map[string]string) {
+	for name, env := range envs {
+		flag := flags.Lookup(name)
+		if flag == nil {
+			panic(fmt.Sprintf("unable to bind %s to unknown flag %s", env, name))
+		}
+		flags.SetAnnotation(name, envAnnotation, []string{env})
+		flag.Usage += fmt.Sprintf(" [$%s]", env)
+	}
+}
+
+// The root command's flags for choosing a config file and profile, and the settings every command shares
+type rootOptions struct {
+	configPath string
+	profile    string
+	rateLimit  float64
+	rateBurst  int
+	output     string
+	logLevel   string
+	logFormat  string
+	logFile    string
+	logRotate  logger.RotateOptions
+	logMaxSize int64
+	// The logger's settings once applyConfig has run, for loggers created by commands
+	logOptions    logger.Options
+	metricsAddr   string
+	trace         string
+	traceEndpoint string
+}
+
+// Where spans are exported with --trace
+const (
+	stdoutTrace = "stdout"
+	otlpTrace   = "otlp"
+)
+
+// How often spans are sent to the collector, and how long the last ones have to be sent before the process exits
+const (
+	traceExportInterval = 5 * time.Second
+	traceShutdownWait   = 5 * time.Second
+)
+
+func (o *rootOptions) addFlags(cmd *cobra.Command) {
+	defaultPath, err := config.DefaultPath()
+	if err != nil {
+		defaultPath = "config.yaml"
+	}
+	flags := cmd.PersistentFlags()
+	flags.StringVar(&o.configPath, "config", defaultPath, "The config file profiles are read from")
+	flags.StringVar(&o.profile, "profile", "", "The profile in the config file to use, defaults to its default_profile")
+	flags.Float64Var(&o.rateLimit, "rate-limit", 0, "The maximum average requests per second sent to the API, 0 for no limit")
+	flags.IntVar(&o.rateBurst, "rate-burst", 1, "How many requests can be sent at once before --rate-limit applies")
+	flags.StringVarP(&o.output, "output", "o", tableOutput, "The format results are written to stdout in (table, json or csv)")
+	flags.StringVar(&o.logLevel, "log-level", "info", "The lowest level logged (debug, info, warn or error)")
+	flags.StringVar(&o.logFormat, "log-format", logger.TextFormat, "The format logs are written to stderr and the log file in (text or json)")
+	flags.StringVar(&o.logFile, "log-file", "app.log", "The file logs are appended to as well as stderr")
+	flags.Int64Var(&o.logMaxSize, "log-max-size", 100, "Rotate the log file before it grows past this many megabytes, 0 to not rotate on size")
+	flags.DurationVar(&o.logRotate.Every, "log-rotate-every", 0, "Rotate the log file on the first write in each period of this length, e.g. 24h for daily at midnight UTC, 0 to not rotate on time")
+	flags.IntVar(&o.logRotate.MaxBackups, "log-max-backups", 10, "How many rotated log files to keep, 0 to keep all of them")
+	flags.BoolVar(&o.logRotate.Compress, "log-compress", true, "Gzip rotated log files")
+	flags.StringVar(&o.metricsAddr, "metrics-addr", "", "Serve Prometheus metrics at /metrics on this address, e.g. :9090, while the command runs")
+	flags.StringVar(&o.trace, "trace", "", "Export spans of each TWAP run and API request (stdout or otlp), not traced if empty")
+	flags.StringVar(&o.traceEndpoint, "trace-endpoint", tracing.DefaultOTLPEndpoint, "The OTLP/HTTP traces endpoint spans are sent to with --trace otlp")
+	bindEnv(flags, map[string]string{
+		"config":           "CONFIG",
+		"profile":          "PROFILE",
+		"rate-limit":       "RATE_LIMIT",
+		"rate-burst":       "RATE_BURST",
+		"output":           "OUTPUT",
+		"log-level":        "LOG_LEVEL",
+		"log-format":       "LOG_FORMAT",
+		"log-file":         "LOG_FILE",
+		"log-max-size":     "LOG_MAX_SIZE",
+		"log-rotate-every": "LOG_ROTATE_EVERY",
+		"log-max-backups":  "LOG_MAX_BACKUPS",
+		"log-compress":     "LOG_COMPRESS",
+		"metrics-addr":     "METRICS_ADDR",
+		"trace":            "TRACE",
+		"trace-endpoint":   "TRACE_ENDPOINT",
+	})
+}
+
+// Fills in every flag the user didn't set on the command line, from its environment variable if set and otherwise from
+// the profile. Flags set by neither keep their defaults, so the precedence is flags > env > profile > defaults
+func (o *rootOptions) applyConfig(cmd *cobra.Command) error {
+	flags := cmd.Flags()
+	// The config file and profile can't come from the profile, so they're resolved first
+	for _, name := range []string{"config", "profile"} {
+		if err := applyEnv(flags.Lookup(name)); err != nil {
+			return err
+		}
+	}
+
+	var (
+		cfg *config.Config
+		err error
+	)
+	if flags.Changed("config") || os.Getenv("CONFIG") != "" {
+		cfg, err = config.Load(o.configPath)
+	} else {
+		cfg, err = config.LoadOptional(o.configPath)
+	}
+	if err != nil {
+		return err
+	}
+	profile, err := cfg.Profile(o.profile)
+	if err != nil {
+		return err
+	}
+
+	values := profile.Values()
+	if err := checkProfileFlags(cmd.Root(), values); err != nil {
+		return err
+	}
+	// Profile credentials would replace a key or secret given by flag or env, which take precedence over the profile
+	if setByUser(flags.Lookup("api-key")) || setByUser(flags.Lookup("api-secret")) {
+		delete(values, "credentials")
+	}
+
+	flags.VisitAll(func(flag *pflag.Flag) {
+		if err != nil || flag.Changed || flag.Name == "config" || flag.Name == "profile" {
+			return
+		}
+		if _, ok := lookupEnv(flag); ok {
+			err = applyEnv(flag)
+			return
+		}
+		if value, ok := values[flag.Name]; ok {
+			if setErr := flag.Value.Set(value); setErr != nil {
+				err = fmt.Errorf("invalid value %q for %s in profile: %w", value, flag.Name, setErr)
+			}
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := checkOutputFormat(o.output); err != nil {
+		return err
+	}
+	if err := o.setupLogger(); err != nil {
+		return err
+	}
+	if o.metricsAddr != "" {
+		addr, err := metrics.Serve(o.metricsAddr)
+		if err != nil {
+			return fmt.Errorf("unable to serve metrics: %w", err)
+		}
+		logger.Info("Serving metrics", "url", fmt.Sprintf("http://%s/metrics", addr))
+	}
+	if err := o.setupTracing(cmd.Root().Name()); err != nil {
+		return err
+	}
+	api.SetRateLimit(o.rateLimit, o.rateBurst)
+	return nil
+}
+
+// Replaces the package logger with one at the configured level and format, writing to stderr and the log file
+func (o *rootOptions) setupLogger() error {
+	level, err := logger.ParseLevel(o.logLevel)
+	if err != nil {
+		return err
+	}
+	o.logRotate.MaxSize = o.logMaxSize << 20
+	o.logOptions = logger.Options{Level: level, Format: o.logFormat, Rotate: o.logRotate}
+	l, file, err := logger.NewFileAndStderrLogger(o.logFile, o.logOptions)
+	if err != nil {
+		return err
+	}
+	logger.SetLogger(l)
+	logFile = file
+	return nil
+}
+
+// Sets the trace exporter from --trace, spans are reported with the service name
+func (o *rootOptions) setupTracing(service string) error {
+	switch o.trace {
+	case "":
+		return nil
+	case stdoutTrace:
+		// JSON output is parsed line by line, so spans can't be mixed into it
+		if o.output == jsonOutput {
+			return fmt.Errorf("--trace stdout can't be used with --output json, use --trace otlp instead")
+		}
+		exporter, err := tracing.NewWriterExporter(os.Stdout)
+		if err != nil {
+			return err
+		}
+		tracing.Setup(service, sdktrace.NewSimpleSpanProcessor(exporter))
+	case otlpTrace:
+		// Sent in batches in the background, so a slow collector doesn't hold up the run
+		exporter, err := tracing.NewOTLPExporter(context.Background(), o.traceEndpoint)
+		if err != nil {
+			return fmt.Errorf("invalid trace endpoint: %w", err)
+		}
+		tracing.Setup(service, sdktrace.NewBatchSpanProcessor(exporter, sdktrace.WithBatchTimeout(traceExportInterval)))
+		logger.Info("Exporting spans", "endpoint", o.traceEndpoint)
+	default:
+		return fmt.Errorf("trace must be stdout or otlp, received: %s", o.trace)
+	}
+	return nil
+}
+
+// Sends the spans that haven't been exported yet and closes the log file once rotated logs are compressed. Call before
+// the process exits
+func Shutdown() {
+	ctx, cancel := context.WithTimeout(context.Background(), traceShutdownWait)
+	defer cancel()
+	if err := tracing.Shutdown(ctx); err != nil {
+		logger.Warn("unable to export spans", logger.ErrorKey, err)
+	}
+	if logFile != nil {
+		logFile.Close()
+	}
+}
+
+// Exits once the last spans are exported
+func exit(code int) {
+	Shutdown()
+	os.Exit(code)
+}
+
+func lookupEnv(flag *pflag.Flag) (string, bool) {
+	env, ok := flag.Annotations[envAnnotation]
+	if !ok {
+		return "", false
+	}
+	return os.LookupEnv(env[0])
+}
+
+// Reports whether a flag was set on the command line or by its environment variable
+func setByUser(flag *pflag.Flag) bool {
+	if flag == nil {
+		return false
+	}
+	_, ok := lookupEnv(flag)
+	return flag.Changed || ok
+}
+
+// Sets a flag from its environment variable unless it was set on the command line. Set through the value so the flag
+// still reads as unchanged
+func applyEnv(flag *pflag.Flag) error {
+	if flag == nil || flag.Changed {
+		return nil
+	}
+	value, ok := lookupEnv(flag)
+	if !ok {
+		return nil
+	}
+	if err := flag.Value.Set(value); err != nil {
+		return fmt.Errorf("invalid value %q for %s in $%s: %w", value, flag.Name, flag.Annotations[envAnnotation][0], err)
+	}
+	return nil
+}
+
+// Flags a profile can't set. Skipping confirmation and the base URL allow-list has to be asked for on each run, and
+// secrets belong in credentials or the environment rather than in plain text in the config file
+var unsafeProfileFlags = []string{"yes", "confirm-production", "insecure-allow-any-url", "api-secret", "notify-secret"}
+
+// Returns an error if the profile sets a flag no command has, so typos in the config file aren't silently ignored, or
+// one of unsafeProfileFlags
+func checkProfileFlags(root *cobra.Command, values map[string]string) error {
+	for _, name := range unsafeProfileFlags {
+		if _, ok := values[name]; ok {
+			return fmt.Errorf("profile can't set %s, pass it on the command line or in the environment instead", name)
+		}
+	}
+
+	known := map[string]bool{}
+	var visit func(cmd *cobra.Command)
+	visit = func(cmd *cobra.Command) {
+		cmd.LocalFlags().VisitAll(func(flag *pflag.Flag) { known[flag.Name] = true })
+		for _, child := range cmd.Commands() {
+			visit(child)
+		}
+	}
+	visit(root)
+
+	unknown := []string{}
+	for name := range values {
+		if !known[name] {
+			unknown = append(unknown, name)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return fmt.Errorf("profile sets unknown flags: %v", unknown)
+	}
+	return nil
+}
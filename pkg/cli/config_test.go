@@ -0,0 +1,139 @@
+package cli
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/cobra"
+)
+
+func TestApplyConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	config := `
+profiles:
+  test:
+    market: PROFILE-MARKET
+    defaults:
+      interval: 10s
+      duration: 1m
+      side: sell
+`
+	if err := os.WriteFile(path, []byte(config), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("CONFIG", path)
+	t.Setenv("PROFILE", "test")
+	t.Setenv("INTERVAL", "20s")
+
+	var side, market, interval, duration, amount string
+	options := &rootOptions{}
+	root := &cobra.Command{
+		Use: "root",
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			return options.applyConfig(cmd)
+		},
+	}
+	options.addFlags(root)
+	child := &cobra.Command{Use: "child", Run: func(cmd *cobra.Command, args []string) {}}
+	child.Flags().StringVar(&side, "side", "", "")
+	child.Flags().StringVar(&market, "market", "", "")
+	child.Flags().StringVar(&interval, "interval", "", "")
+	child.Flags().StringVar(&duration, "duration", "", "")
+	child.Flags().StringVar(&amount, "amount", "5", "")
+	bindEnv(child.Flags(), map[string]string{"side": "TEST_SIDE", "interval": "INTERVAL"})
+	root.AddCommand(child)
+
+	root.SetArgs([]string{"child", "--side", "buy", "--log-file", filepath.Join(t.TempDir(), "test.log")})
+	if err := root.Execute(); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string][2]string{
+		"side":     {"buy", side},
+		"interval": {"20s", interval},
+		"market":   {"PROFILE-MARKET", market},
+		"duration": {"1m", duration},
+		"amount":   {"5", amount},
+	}
+	for name, values := range expected {
+		if values[0] != values[1] {
+			t.Errorf("expected %s to be %s, got: %s", name, values[0], values[1])
+		}
+	}
+	if child.Flags().Changed("market") {
+		t.Errorf("expected flags set from the profile to read as unchanged")
+	}
+}
+
+func TestApplyConfigCredentials(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	config := `
+profiles:
+  test:
+    credentials: keychain:test
+`
+	if err := os.WriteFile(path, []byte(config), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("CONFIG", path)
+	t.Setenv("PROFILE", "test")
+	for _, env := range []string{"API_KEY", "API_SECRET"} {
+		t.Setenv(env, "")
+		os.Unsetenv(env)
+	}
+
+	for _, test := range []struct {
+		args     []string
+		env      string
+		expected string
+	}{
+		{nil, "", "keychain:test"},
+		{[]string{"--api-key", "key"}, "", ""},
+		{nil, "secret", ""},
+	} {
+		if test.env != "" {
+			t.Setenv("API_SECRET", test.env)
+		}
+		options := &rootOptions{}
+		connection := &connectionOptions{}
+		root := &cobra.Command{
+			Use: "root",
+			PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+				return options.applyConfig(cmd)
+			},
+		}
+		options.addFlags(root)
+		child := &cobra.Command{Use: "child", Run: func(cmd *cobra.Command, args []string) {}}
+		connection.addFlags(child.Flags())
+		root.AddCommand(child)
+
+		root.SetArgs(append([]string{"child", "--log-file", filepath.Join(t.TempDir(), "test.log")}, test.args...))
+		if err := root.Execute(); err != nil {
+			t.Fatal(err)
+		}
+		// A key or secret from a flag or env isn't replaced by the profile's credentials
+		if connection.credentials != test.expected {
+			t.Errorf("args %v, env %q: expected credentials %q, got: %q", test.args, test.env, test.expected, connection.credentials)
+		}
+	}
+}
+
+func TestCheckProfileFlags(t *testing.T) {
+	root := &cobra.Command{Use: "root"}
+	child := &cobra.Command{Use: "child"}
+	child.Flags().String("market", "", "")
+	child.Flags().Bool("yes", false, "")
+	child.Flags().String("notify-secret", "", "")
+	(&connectionOptions{}).addFlags(child.Flags())
+	root.AddCommand(child)
+
+	if err := checkProfileFlags(root, map[string]string{"market": "AVAX-USDC", "api-key": "key"}); err != nil {
+		t.Errorf("expected known flags to be allowed, got: %v", err)
+	}
+	for _, name := range []string{"markt", "yes", "confirm-production", "insecure-allow-any-url", "api-secret", "notify-secret"} {
+		if err := checkProfileFlags(root, map[string]string{name: "true"}); err == nil {
+			t.Errorf("expected the profile setting %s to fail", name)
+		}
+	}
+}
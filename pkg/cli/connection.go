@@ -0,0 +1,113 @@
+package cli
+
+import (
+	"fmt"
+
+	"github.com/garry-sharp/enclave-assessment/pkg/api"
+	"github.com/garry-sharp/enclave-assessment/pkg/logger"
+
+	"github.com/spf13/pflag"
+)
+
+// The flags every command that talks to the API shares: credentials and the base URL
+type connectionOptions struct {
+	apiKey      string
+	apiSecret   string
+	credentials string
+	keysDir     string
+	baseURL     string
+	allowedURLs []string
+	allowAnyURL bool
+	auditLog    string
+
+	// The audit log opened by startAudit, nil until then or if it is off
+	audit *api.AuditLog
+}
+
+func (o *connectionOptions) addFlags(flags *pflag.FlagSet) {
+	flags.StringVar(&o.apiKey, "api-key", "", "The Enclave.markets API key")
+	flags.StringVar(&o.apiSecret, "api-secret", "", "The Enclave.markets API key")
+	flags.StringVar(&o.credentials, "credentials", "", "Where to load the API key and secret from instead of --api-key and --api-secret\nOne of env, env:KEY_VAR,SECRET_VAR, file:<name> for a key added with the keys command, or command:<command> for a command that prints {\"apiKey\", \"apiSecret\"} JSON")
+	flags.StringVar(&o.keysDir, "keys-dir", defaultKeysDir(), "The directory encrypted keys are stored in")
+	flags.StringVar(&o.baseURL, "base-url", api.SandboxBaseURL, "The base url for the Enclave.markets API")
+	flags.StringSliceVar(&o.allowedURLs, "allowed-base-urls", nil, "Comma separated base urls --base-url must be one of, defaults to the Enclave.markets production, staging and sandbox urls")
+	flags.BoolVar(&o.allowAnyURL, "insecure-allow-any-url", false, "Allow a --base-url outside of --allowed-base-urls, e.g. a local mock. For testing only")
+	flags.StringVar(&o.auditLog, "audit-log", defaultAuditLog(), "The hash-chained file every signed request and its response are recorded in, empty to not record them")
+	bindEnv(flags, map[string]string{
+		"api-key":           "API_KEY",
+		"api-secret":        "API_SECRET",
+		"credentials":       "CREDENTIALS",
+		"keys-dir":          "KEYS_DIR",
+		"base-url":          "BASE_URL",
+		"allowed-base-urls": "ALLOWED_BASE_URLS",
+		"audit-log":         "AUDIT_LOG",
+	})
+}
+
+// Loads the api key and secret from --credentials if it is set, otherwise they are taken from the flags as they are
+func (o *connectionOptions) resolveCredentials() error {
+	if o.credentials == "" {
+		return nil
+	}
+	creds, err := resolveCredentials(o.credentials, o.keysDir)
+	if err != nil {
+		return fmt.Errorf("unable to load credentials: %w", err)
+	}
+	o.apiKey, o.apiSecret = creds.APIKey, creds.APISecret
+	return nil
+}
+
+// Records signed requests in the audit log unless --audit-log is empty
+func (o *connectionOptions) startAudit() error {
+	if o.auditLog == "" {
+		return nil
+	}
+	audit, err := api.OpenAuditLog(o.auditLog)
+	if err != nil {
+		return fmt.Errorf("unable to open the audit log: %w", err)
+	}
+	api.SetAuditLog(audit)
+	o.audit = audit
+	return nil
+}
+
+// Stops recording to the audit log and closes it, deferred by each command once it connects
+func (o *connectionOptions) close() {
+	if o.audit == nil {
+		return
+	}
+	api.SetAuditLog(nil)
+	if err := o.audit.Close(); err != nil {
+		logger.Warn("unable to close the audit log", logger.ErrorKey, err)
+	}
+	o.audit = nil
+}
+
+// Checks the base URL against the allow-list and configures the api package. Without an api key only public endpoints
+// can be used, which is an error unless authed is false
+func (o *connectionOptions) connect(authed bool) (err error) {
+	if err := o.resolveCredentials(); err != nil {
+		return err
+	}
+	if err := o.startAudit(); err != nil {
+		return err
+	}
+	// The command only closes the audit log once it has connected
+	defer func() {
+		if err != nil {
+			o.close()
+		}
+	}()
+	if o.allowAnyURL {
+		if api.ValidateBaseURL(o.baseURL, o.allowedURLs) != nil {
+			logger.Warn("base-url is not in the allow-list, continuing as --insecure-allow-any-url is set", "base_url", o.baseURL)
+		}
+	} else if err := api.ValidateBaseURL(o.baseURL, o.allowedURLs); err != nil {
+		return fmt.Errorf("%w, use --insecure-allow-any-url to allow it for local testing", err)
+	}
+
+	if !authed && (o.apiKey == "" || o.apiSecret == "") {
+		return api.LoadPublic(o.baseURL)
+	}
+	return api.Load(o.apiKey, o.apiSecret, o.baseURL)
+}
@@ -0,0 +1,236 @@
+package cli
+
+import (
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/garry-sharp/enclave-assessment/pkg/logger"
+	"github.com/garry-sharp/enclave-assessment/pkg/twap"
+
+	"golang.org/x/term"
+)
+
+// The width the dashboard is drawn at when the terminal's can't be read
+const defaultDashboardWidth = 80
+
+// How often the countdown and ETA are redrawn between events
+const dashboardRefresh = 250 * time.Millisecond
+
+// A live view of a running TWAP drawn in place on the terminal, updated from the engine's events. Logs are kept off
+// stderr while it is drawn so they don't break it up, they still go to the log file
+type dashboard struct {
+	out   io.Writer
+	width int
+
+	mu    sync.Mutex
+	drawn int
+	done  chan struct{}
+	// Resumes logging to stderr once the dashboard stops, nil until it starts
+	resume func()
+
+	runID    string
+	plan     *twap.Plan
+	started  time.Time
+	executed *big.Float
+	percent  float64
+	// Slices placed at least once, so the ones still to come are known
+	placed     map[int]bool
+	filled     int
+	failed     int
+	retries    int
+	lastPrice  *big.Float
+	filledSize *big.Float
+	filledCost *big.Float
+	lastError  string
+	status     string
+}
+
+func newDashboard(out io.Writer, width int) *dashboard {
+	return &dashboard{out: out, width: width, placed: map[int]bool{}, filledSize: big.NewFloat(0), filledCost: big.NewFloat(0), status: "starting"}
+}
+
+// A dashboard drawn on stdout at the terminal's width, nil if stdout isn't a terminal
+func newTerminalDashboard() *dashboard {
+	fd := int(os.Stdout.Fd())
+	if !term.IsTerminal(fd) {
+		return nil
+	}
+	width, _, err := term.GetSize(fd)
+	if err != nil || width <= 0 {
+		width = defaultDashboardWidth
+	}
+	return newDashboard(os.Stdout, width)
+}
+
+// Handles an engine event, the dashboard is first drawn when the run starts
+func (d *dashboard) event(event twap.Event) {
+	d.mu.Lock()
+	d.update(event)
+	start := event.Type == twap.EventStarted && d.done == nil
+	if start {
+		d.done = make(chan struct{})
+		d.resume = logger.PauseStderr()
+		go d.refresh(d.done)
+	}
+	d.mu.Unlock()
+	d.draw(time.Now())
+}
+
+func (d *dashboard) update(event twap.Event) {
+	if event.Executed != nil {
+		d.executed, d.percent = event.Executed, event.CompletionPercent
+	}
+	switch event.Type {
+	case twap.EventStarted:
+		d.runID, d.plan, d.started, d.status = event.RunID, event.Plan, event.Time, "running"
+	case twap.EventSlicePlaced:
+		d.placed[*event.Slice] = true
+	case twap.EventSliceFilled:
+		d.filled++
+		d.lastPrice = event.Price
+		if event.Size != nil && event.Cost != nil {
+			d.filledSize.Add(d.filledSize, event.Size)
+			d.filledCost.Add(d.filledCost, event.Cost)
+		}
+	case twap.EventSliceRetry:
+		d.retries++
+		d.lastError = fmt.Sprintf("slice %d: %s", *event.Slice, event.Error)
+	case twap.EventSliceFailed:
+		d.failed++
+		d.lastError = fmt.Sprintf("slice %d: %s", *event.Slice, event.Error)
+	case twap.EventAborted:
+		d.status = "aborted: " + event.Reason
+	case twap.EventCompleted:
+		if !strings.HasPrefix(d.status, "aborted") {
+			d.status = "completed"
+		}
+	}
+}
+
+// Redraws until done is closed by stop
+func (d *dashboard) refresh(done chan struct{}) {
+	ticker := time.NewTicker(dashboardRefresh)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case now := <-ticker.C:
+			d.draw(now)
+		}
+	}
+}
+
+// Draws the last frame and hands the terminal back to the logs, nothing is drawn after it
+func (d *dashboard) stop() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.done == nil {
+		return
+	}
+	d.frame(time.Now())
+	close(d.done)
+	d.resume()
+	d.done, d.resume = nil, nil
+}
+
+func (d *dashboard) draw(now time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.done != nil {
+		d.frame(now)
+	}
+}
+
+// Redraws over the last frame. Lines are cut to the terminal width, as wrapped lines would throw off the cursor
+func (d *dashboard) frame(now time.Time) {
+	b := &strings.Builder{}
+	if d.drawn > 0 {
+		fmt.Fprintf(b, "\x1b[%dA", d.drawn)
+	}
+	lines := d.render(now)
+	for _, line := range lines {
+		fmt.Fprintf(b, "\r\x1b[2K%s\n", truncate(line, d.width))
+	}
+	d.drawn = len(lines)
+	io.WriteString(d.out, b.String())
+}
+
+func (d *dashboard) render(now time.Time) []string {
+	plan := d.plan
+	asset := plan.AmountAsset()
+	executed := d.executed
+	if executed == nil {
+		executed = big.NewFloat(0)
+	}
+
+	// The bar fills what's left of the line after the percentage and amounts
+	amounts := fmt.Sprintf(" %5.1f%%  %s / %s %s", d.percent, executed.Text('f', -1), plan.Amount.Text('f', -1), asset)
+	barWidth := max(10, d.width-len(amounts)-2)
+	filled := min(barWidth, int(d.percent/100*float64(barWidth)))
+	bar := "[" + strings.Repeat("█", filled) + strings.Repeat("░", barWidth-filled) + "]" + amounts
+
+	average := "-"
+	if d.filledSize.Sign() > 0 {
+		average = new(big.Float).Quo(d.filledCost, d.filledSize).Text('f', 6)
+	}
+	lastPrice := "-"
+	if d.lastPrice != nil {
+		lastPrice = d.lastPrice.Text('f', -1)
+	}
+
+	errors := fmt.Sprintf("%d failed, %d retried", d.failed, d.retries)
+	if d.lastError != "" {
+		errors += ", last " + d.lastError
+	}
+
+	return []string{
+		fmt.Sprintf("TWAP %s  %s %s %s on %s", d.runID, plan.Side, plan.Amount.Text('f', -1), asset, plan.Market),
+		bar,
+		fmt.Sprintf("Slices      %d of %d placed, %d filled", len(d.placed), plan.Slices, d.filled),
+		fmt.Sprintf("Next slice  %s", d.nextSlice(now)),
+		fmt.Sprintf("Last fill   %s  Average %s", lastPrice, average),
+		fmt.Sprintf("Errors      %s", errors),
+		fmt.Sprintf("ETA         %s", d.eta(now)),
+		fmt.Sprintf("Status      %s", d.status),
+	}
+}
+
+// Slices are placed on ticks of the interval from the start, the first straight away
+func (d *dashboard) nextTick(now time.Time) time.Time {
+	ticks := now.Sub(d.started) / d.plan.Interval
+	return d.started.Add((ticks + 1) * d.plan.Interval)
+}
+
+func (d *dashboard) nextSlice(now time.Time) string {
+	if d.status != "running" || len(d.placed) >= d.plan.Slices {
+		return "-"
+	}
+	return d.nextTick(now).Sub(now).Round(time.Second).String()
+}
+
+// When the last slice will be placed if no more are deferred
+func (d *dashboard) eta(now time.Time) string {
+	remaining := d.plan.Slices - len(d.placed)
+	switch {
+	case d.status != "running":
+		return "-"
+	case remaining <= 0:
+		return "waiting for the last fills"
+	}
+	end := d.nextTick(now).Add(time.Duration(remaining-1) * d.plan.Interval)
+	return fmt.Sprintf("%s (%s)", end.Sub(now).Round(time.Second), end.Local().Format(time.TimeOnly))
+}
+
+func truncate(line string, width int) string {
+	if width <= 0 || utf8.RuneCountInString(line) <= width {
+		return line
+	}
+	return string([]rune(line)[:width])
+}
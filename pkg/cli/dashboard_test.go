@@ -0,0 +1,98 @@
+package cli
+
+import (
+	"bytes"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/garry-sharp/enclave-assessment/pkg/api"
+	"github.com/garry-sharp/enclave-assessment/pkg/twap"
+)
+
+func TestDashboard(t *testing.T) {
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	plan := &twap.Plan{Market: "AVAX-USDC", Side: "buy", Denomination: api.QUOTE, BaseAsset: "AVAX", QuoteAsset: "USDC", Amount: big.NewFloat(100), Slices: 4, Interval: 10 * time.Second}
+	slice := func(t twap.EventType, i int) twap.Event {
+		return twap.Event{Type: t, Slice: &i}
+	}
+	filled := slice(twap.EventSliceFilled, 0)
+	filled.Price, filled.Size, filled.Cost, filled.Executed, filled.CompletionPercent = big.NewFloat(20), big.NewFloat(1.25), big.NewFloat(25), big.NewFloat(25), 25
+	retry := slice(twap.EventSliceRetry, 1)
+	retry.Error = "timeout"
+
+	d := newDashboard(&bytes.Buffer{}, 60)
+	for _, event := range []twap.Event{
+		{Type: twap.EventStarted, RunID: "run", Time: start, Plan: plan},
+		slice(twap.EventSlicePlaced, 0),
+		filled,
+		slice(twap.EventSlicePlaced, 1),
+		retry,
+	} {
+		d.update(event)
+	}
+
+	lines := d.render(start.Add(13 * time.Second))
+	expected := map[int]string{
+		0: "TWAP run  buy 100 USDC on AVAX-USDC",
+		2: "Slices      2 of 4 placed, 1 filled",
+		3: "Next slice  7s",
+		4: "Last fill   20  Average 20.000000",
+		5: "Errors      0 failed, 1 retried, last slice 1: timeout",
+		7: "Status      running",
+	}
+	for i, line := range expected {
+		if lines[i] != line {
+			t.Errorf("line %d: expected %q, got %q", i, line, lines[i])
+		}
+	}
+	// A quarter of the bar is filled, and it fits the width with the amounts
+	if !strings.HasPrefix(lines[1], "["+strings.Repeat("█", 9)+"░") || !strings.HasSuffix(lines[1], " 25.0%  25 / 100 USDC") || len([]rune(lines[1])) != 60 {
+		t.Errorf("unexpected progress bar: %q", lines[1])
+	}
+	// Slices 2 and 3 are placed on the next two ticks
+	if !strings.HasPrefix(lines[6], "ETA         17s (") {
+		t.Errorf("unexpected ETA: %q", lines[6])
+	}
+
+	d.update(twap.Event{Type: twap.EventAborted, Reason: "risk limit"})
+	d.update(twap.Event{Type: twap.EventCompleted})
+	lines = d.render(start.Add(15 * time.Second))
+	if lines[3] != "Next slice  -" || lines[6] != "ETA         -" || lines[7] != "Status      aborted: risk limit" {
+		t.Errorf("expected an aborted run, got: %q", lines[3:])
+	}
+}
+
+func TestDashboardDraw(t *testing.T) {
+	buf := &bytes.Buffer{}
+	d := newDashboard(buf, 20)
+	plan := &twap.Plan{Market: "AVAX-USDC", Side: "sell", Denomination: api.BASE, BaseAsset: "AVAX", QuoteAsset: "USDC", Amount: big.NewFloat(10), Slices: 2, Interval: time.Minute}
+
+	// Nothing is drawn before the run starts or after the dashboard stops
+	d.draw(time.Now())
+	if buf.Len() != 0 {
+		t.Fatalf("expected nothing drawn before the start, got %q", buf.String())
+	}
+	d.event(twap.Event{Type: twap.EventStarted, RunID: "run", Time: time.Now(), Plan: plan})
+	d.event(twap.Event{Type: twap.EventCompleted})
+	d.stop()
+	d.stop()
+	d.draw(time.Now())
+
+	// Each frame after the first moves back up over the last one, and lines are cut to the width. The countdown may
+	// have redrawn it in between
+	frames := strings.Split(buf.String(), "\x1b[8A")
+	last := frames[len(frames)-1]
+	if len(frames) < 3 || strings.Count(frames[0], "\n") != 8 {
+		t.Fatalf("expected at least 3 frames of 8 lines, got %q", buf.String())
+	}
+	for _, line := range strings.Split(strings.TrimSuffix(last, "\n"), "\n") {
+		if text := strings.TrimPrefix(line, "\r\x1b[2K"); len([]rune(text)) > 20 {
+			t.Errorf("expected lines cut to 20 characters, got %q", text)
+		}
+	}
+	if !strings.Contains(last, "Status      complete") {
+		t.Errorf("expected the last frame to show the run completed, got %q", last)
+	}
+}
@@ -0,0 +1,161 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/garry-sharp/enclave-assessment/pkg/api"
+
+	"github.com/spf13/cobra"
+)
+
+// Set to unlock encrypted keys without a prompt, e.g. in CI
+const passphraseEnv = "KEYS_PASSPHRASE"
+
+// A stored key as written by the keys commands
+type keyInfo struct {
+	Name string `json:"name"`
+	Path string `json:"path"`
+}
+
+func (k keyInfo) row() []string {
+	return []string{k.Name, k.Path}
+}
+
+var keyHeader = []string{"name", "path"}
+
+func getKeysCommand(options *rootOptions) *cobra.Command {
+	var keysDir string
+
+	keysCmd := &cobra.Command{
+		Use:   "keys",
+		Short: "Manage named API keys stored in encrypted files",
+	}
+	keysCmd.PersistentFlags().StringVar(&keysDir, "keys-dir", defaultKeysDir(), "The directory encrypted keys are stored in")
+	bindEnv(keysCmd.PersistentFlags(), map[string]string{"keys-dir": "KEYS_DIR"})
+
+	addCmd := &cobra.Command{
+		Use:   "add <name>",
+		Short: "Encrypt an API key and secret with a passphrase and store it under a name",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			path, err := api.KeyPath(keysDir, args[0])
+			if err != nil {
+				return err
+			}
+			if _, err := os.Stat(path); err == nil {
+				return fmt.Errorf("key %s already exists, remove it first", args[0])
+			}
+
+			creds := api.Credentials{}
+			if creds.APIKey, err = prompt("API key: ", false); err != nil {
+				return err
+			}
+			if creds.APISecret, err = prompt("API secret: ", true); err != nil {
+				return err
+			}
+			passphrase, err := newPassphrase()
+			if err != nil {
+				return err
+			}
+
+			if err := api.WriteEncryptedCredentials(path, passphrase, creds); err != nil {
+				return err
+			}
+			key := keyInfo{Name: args[0], Path: path}
+			return writeOutput(cmd.OutOrStdout(), options.output, key, table{header: keyHeader, rows: [][]string{key.row()}})
+		},
+	}
+
+	listCmd := &cobra.Command{
+		Use:   "list",
+		Short: "List the names of stored keys",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			names, err := api.ListKeys(keysDir)
+			if err != nil {
+				return err
+			}
+			keys := []keyInfo{}
+			t := table{header: keyHeader}
+			for _, name := range names {
+				path, err := api.KeyPath(keysDir, name)
+				if err != nil {
+					return err
+				}
+				keys = append(keys, keyInfo{Name: name, Path: path})
+				t.rows = append(t.rows, keys[len(keys)-1].row())
+			}
+			return writeOutput(cmd.OutOrStdout(), options.output, keys, t)
+		},
+	}
+
+	removeCmd := &cobra.Command{
+		Use:   "remove <name>",
+		Short: "Delete a stored key",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			path, err := api.KeyPath(keysDir, args[0])
+			if err != nil {
+				return err
+			}
+			if err := api.RemoveKey(keysDir, args[0]); err != nil {
+				return err
+			}
+			key := keyInfo{Name: args[0], Path: path}
+			return writeOutput(cmd.OutOrStdout(), options.output, key, table{header: keyHeader, rows: [][]string{key.row()}})
+		},
+	}
+
+	keysCmd.AddCommand(addCmd, listCmd, removeCmd)
+	return keysCmd
+}
+
+// Resolves the api key and secret from a credential reference, see api.ParseCredentialSource
+func resolveCredentials(ref, keysDir string) (api.Credentials, error) {
+	provider, err := api.ParseCredentialSource(ref, keysDir, passphrase)
+	if err != nil {
+		return api.Credentials{}, err
+	}
+	return provider.Credentials(context.Background())
+}
+
+func defaultKeysDir() string {
+	dir, err := api.DefaultKeysDir()
+	if err != nil {
+		return ".keys"
+	}
+	return dir
+}
+
+// Reads the passphrase for an encrypted key from the environment or prompts for it
+func passphrase() (string, error) {
+	if value, ok := os.LookupEnv(passphraseEnv); ok {
+		return value, nil
+	}
+	return prompt("Passphrase: ", true)
+}
+
+// Prompts for a passphrase for a new key, asking for it twice when stdin is a terminal
+func newPassphrase() (string, error) {
+	if value, ok := os.LookupEnv(passphraseEnv); ok {
+		return value, nil
+	}
+	first, err := prompt("Passphrase: ", true)
+	if err != nil {
+		return "", err
+	}
+	if !isTerminal() {
+		return first, nil
+	}
+	second, err := prompt("Confirm passphrase: ", true)
+	if err != nil {
+		return "", err
+	}
+	if first != second {
+		return "", errors.New("passphrases do not match")
+	}
+	return first, nil
+}
@@ -0,0 +1,103 @@
+package cli
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/garry-sharp/enclave-assessment/pkg/twap"
+)
+
+const (
+	tableOutput = "table"
+	jsonOutput  = "json"
+	csvOutput   = "csv"
+)
+
+func checkOutputFormat(format string) error {
+	switch format {
+	case tableOutput, jsonOutput, csvOutput:
+		return nil
+	}
+	return fmt.Errorf("output must be one of table, json or csv, received: %s", format)
+}
+
+// Rows of a result for the table and CSV formats
+type table struct {
+	header []string
+	rows   [][]string
+}
+
+// Writes a result in the format. JSON is the value as returned by the API, table and CSV use the rows
+func writeOutput(w io.Writer, format string, value any, t table) error {
+	switch format {
+	case jsonOutput:
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(value)
+	case csvOutput:
+		cw := csv.NewWriter(w)
+		if err := cw.Write(t.header); err != nil {
+			return err
+		}
+		if err := cw.WriteAll(t.rows); err != nil {
+			return err
+		}
+		return cw.Error()
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, strings.Join(t.header, "\t"))
+	for _, row := range t.rows {
+		fmt.Fprintln(tw, strings.Join(row, "\t"))
+	}
+	return tw.Flush()
+}
+
+// The twap command's JSON output, one JSON object per line. Each progress event is written as it happens, followed by
+// a single result line with the report if the run finishes or an error line if it doesn't
+type twapStream struct {
+	mu      sync.Mutex
+	encoder *json.Encoder
+}
+
+// The last line of the twap command's JSON output. Report is set for a result and Error for an error, or for a result
+// if the run was aborted
+type twapResult struct {
+	Type   string       `json:"type"`
+	Time   time.Time    `json:"time"`
+	Report *twap.Report `json:"report,omitempty"`
+	Error  string       `json:"error,omitempty"`
+}
+
+func newTwapStream(w io.Writer) *twapStream {
+	return &twapStream{encoder: json.NewEncoder(w)}
+}
+
+func (s *twapStream) write(value any) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.encoder.Encode(value)
+}
+
+func (s *twapStream) event(event twap.Event) {
+	s.write(event)
+}
+
+// Writes the result line, err is the abort error if the run was aborted and nil otherwise
+func (s *twapStream) result(report *twap.Report, err error) error {
+	result := twapResult{Type: "result", Time: time.Now(), Report: report}
+	if err != nil {
+		result.Error = err.Error()
+	}
+	return s.write(result)
+}
+
+func (s *twapStream) error(err error) error {
+	return s.write(twapResult{Type: "error", Time: time.Now(), Error: err.Error()})
+}
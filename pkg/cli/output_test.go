@@ -0,0 +1,79 @@
+package cli
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"testing"
+
+	"github.com/garry-sharp/enclave-assessment/pkg/api"
+	"github.com/garry-sharp/enclave-assessment/pkg/twap"
+)
+
+func TestWriteOutput(t *testing.T) {
+	value := []map[string]string{{"coin": "AVAX", "total": "1.5"}}
+	rows := table{header: []string{"coin", "total"}, rows: [][]string{{"AVAX", "1.5"}}}
+
+	cases := map[string]string{
+		tableOutput: "coin  total\nAVAX  1.5\n",
+		csvOutput:   "coin,total\nAVAX,1.5\n",
+		jsonOutput:  "[\n  {\n    \"coin\": \"AVAX\",\n    \"total\": \"1.5\"\n  }\n]\n",
+	}
+	for format, expected := range cases {
+		buf := &bytes.Buffer{}
+		if err := writeOutput(buf, format, value, rows); err != nil {
+			t.Fatalf("%s: unexpected error: %v", format, err)
+		}
+		if buf.String() != expected {
+			t.Errorf("%s: expected %q, got %q", format, expected, buf.String())
+		}
+	}
+
+	if err := checkOutputFormat("yaml"); err == nil {
+		t.Errorf("expected error for unknown format, got nil")
+	}
+}
+
+func TestTwapStream(t *testing.T) {
+	buf := &bytes.Buffer{}
+	stream := newTwapStream(buf)
+	stream.event(twap.Event{Type: twap.EventStarted, Market: "AVAX-USDC"})
+	stream.event(twap.Event{Type: twap.EventCompleted, Market: "AVAX-USDC"})
+	report := twap.NewReport("AVAX-USDC", "buy", api.QUOTE, big.NewFloat(100), big.NewFloat(10), 2)
+	report.Finalize(nil)
+	if err := stream.result(report, nil); err != nil {
+		t.Fatal(err)
+	}
+	stream.result(report, fmt.Errorf("%w: risk limit", twap.ErrAborted))
+	stream.error(errors.New("unused"))
+
+	// One object per line, each with a type
+	types := []string{}
+	scanner := bufio.NewScanner(buf)
+	for scanner.Scan() {
+		line := map[string]any{}
+		if err := json.Unmarshal(scanner.Bytes(), &line); err != nil {
+			t.Fatalf("invalid line %q: %v", scanner.Text(), err)
+		}
+		types = append(types, line["type"].(string))
+		if line["type"] == "result" && line["report"].(map[string]any)["market"] != "AVAX-USDC" {
+			t.Errorf("expected the report in the result, got: %s", scanner.Text())
+		}
+		// Only an aborted run's result has an error
+		if line["type"] == "result" && (line["error"] != nil) != (len(types) == 4) {
+			t.Errorf("unexpected error in result %d: %s", len(types), scanner.Text())
+		}
+	}
+	expected := []string{"started", "completed", "result", "result", "error"}
+	if len(types) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, types)
+	}
+	for i := range expected {
+		if types[i] != expected[i] {
+			t.Errorf("expected %v, got %v", expected, types)
+		}
+	}
+}
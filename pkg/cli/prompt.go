@@ -0,0 +1,86 @@
+package cli
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/garry-sharp/enclave-assessment/pkg/api"
+	"github.com/garry-sharp/enclave-assessment/pkg/twap"
+
+	"golang.org/x/term"
+)
+
+var stdin = bufio.NewReader(os.Stdin)
+
+func isTerminal() bool {
+	return term.IsTerminal(int(os.Stdin.Fd()))
+}
+
+// Prompts on stderr and reads a line from stdin, without echoing it if hidden and stdin is a terminal
+func prompt(label string, hidden bool) (string, error) {
+	fmt.Fprint(os.Stderr, label)
+
+	var value string
+	if hidden && isTerminal() {
+		raw, err := term.ReadPassword(int(os.Stdin.Fd()))
+		fmt.Fprintln(os.Stderr)
+		if err != nil {
+			return "", err
+		}
+		value = string(raw)
+	} else {
+		line, err := stdin.ReadString('\n')
+		if err != nil && line == "" {
+			return "", fmt.Errorf("unable to read %s%w", strings.ToLower(label), err)
+		}
+		value = line
+	}
+
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return "", fmt.Errorf("%s must not be empty", strings.TrimSuffix(label, ": "))
+	}
+	return value, nil
+}
+
+// Prints a banner when trading on production and asks for it to be confirmed by typing production. Asked before the
+// keys are used, as checking them places a probe order. Only skipped by --confirm-production, not --yes, and fails if
+// there is no terminal to ask on
+func confirmEnvironment(baseURL string, confirmed bool) error {
+	if !api.IsProduction(baseURL) {
+		return nil
+	}
+	banner := strings.Repeat("!", 72)
+	fmt.Fprintf(os.Stderr, "%s\n!! PRODUCTION: %s\n!! Orders will trade real funds\n%s\n", banner, baseURL, banner)
+	return confirm("production", "confirm-production", confirmed)
+}
+
+// Prints the plan and asks for it to be confirmed by typing yes. Skipped if yes is set, and fails if there is no terminal
+// to ask on
+func confirmPlan(plan *twap.Plan, yes bool) error {
+	if err := plan.WriteTable(os.Stderr); err != nil {
+		return err
+	}
+	return confirm("yes", "yes", yes)
+}
+
+// Asks for expected to be typed unless skip is set by the named flag
+func confirm(expected, flag string, skip bool) error {
+	if skip {
+		return nil
+	}
+	if !isTerminal() {
+		return fmt.Errorf("refusing to start without confirmation, set --%s to run non-interactively", flag)
+	}
+	answer, err := prompt(fmt.Sprintf(`Type "%s" to start the TWAP: `, expected), false)
+	if err != nil {
+		return err
+	}
+	if answer != expected {
+		return errors.New("TWAP not confirmed")
+	}
+	return nil
+}
@@ -0,0 +1,153 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// The config file, a set of named profiles for each environment
+type Config struct {
+	// The profile used when --profile isn't set
+	DefaultProfile string             `yaml:"default_profile"`
+	Profiles       map[string]Profile `yaml:"profiles"`
+}
+
+// Settings for one environment. Anything left out falls back to the flag defaults
+type Profile struct {
+	BaseURL string `yaml:"base_url"`
+	// The base URLs the profile may use, defaults to the Enclave hosts
+	AllowedBaseURLs []string `yaml:"allowed_base_urls"`
+	// A credential reference, see api.ParseCredentialSource
+	Credentials string    `yaml:"credentials"`
+	Market      string    `yaml:"market"`
+	RateLimit   RateLimit `yaml:"rate_limit"`
+	Risk        Risk      `yaml:"risk"`
+	Notify      Notify    `yaml:"notify"`
+	// Default values for any other flag, keyed by flag name e.g. interval: 30s
+	Defaults map[string]string `yaml:"defaults"`
+}
+
+type RateLimit struct {
+	RequestsPerSecond float64 `yaml:"requests_per_second"`
+	Burst             int     `yaml:"burst"`
+}
+
+// Risk limits for the TWAP. Notional is in the quote currency
+type Risk struct {
+	MaxNotional      string   `yaml:"max_notional"`
+	MaxSliceNotional string   `yaml:"max_slice_notional"`
+	MaxDailyNotional string   `yaml:"max_daily_notional"`
+	AllowedMarkets   []string `yaml:"allowed_markets"`
+	AllowedSides     []string `yaml:"allowed_sides"`
+}
+
+// The webhook TWAP runs in this environment are reported to
+type Notify struct {
+	URL string `yaml:"url"`
+	// json or slack
+	Format string `yaml:"format"`
+	// Signs each payload. Rejected when the profile is applied so the secret isn't kept in the file, set $NOTIFY_SECRET
+	// instead
+	Secret string `yaml:"secret"`
+}
+
+// The config file used when --config isn't set
+func DefaultPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "enclave-twap", "config.yaml"), nil
+}
+
+// Reads the config file at path
+func Load(path string) (*Config, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	config := &Config{}
+	if err := yaml.Unmarshal(raw, config); err != nil {
+		return nil, fmt.Errorf("unable to parse config %s: %w", path, err)
+	}
+	if config.DefaultProfile != "" {
+		if _, ok := config.Profiles[config.DefaultProfile]; !ok {
+			return nil, fmt.Errorf("default_profile %s is not defined in %s", config.DefaultProfile, path)
+		}
+	}
+	return config, nil
+}
+
+// Reads the config file at path, returning an empty config if it doesn't exist
+func LoadOptional(path string) (*Config, error) {
+	config, err := Load(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return &Config{}, nil
+	}
+	return config, err
+}
+
+// Returns the named profile, or the default profile if name is empty. An empty profile is returned if neither is set
+func (c *Config) Profile(name string) (Profile, error) {
+	if name == "" {
+		name = c.DefaultProfile
+	}
+	if name == "" {
+		return Profile{}, nil
+	}
+	profile, ok := c.Profiles[name]
+	if !ok {
+		return Profile{}, fmt.Errorf("profile %s not found, available profiles: %v", name, c.ProfileNames())
+	}
+	return profile, nil
+}
+
+func (c *Config) ProfileNames() []string {
+	names := make([]string, 0, len(c.Profiles))
+	for name := range c.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// The profile as flag values keyed by flag name. The named fields take precedence over the same flag in Defaults
+func (p Profile) Values() map[string]string {
+	values := map[string]string{}
+	for name, value := range p.Defaults {
+		values[name] = value
+	}
+
+	set := func(name, value string) {
+		if value != "" {
+			values[name] = value
+		}
+	}
+	set("base-url", p.BaseURL)
+	set("allowed-base-urls", strings.Join(p.AllowedBaseURLs, ","))
+	set("credentials", p.Credentials)
+	set("market", p.Market)
+	set("max-notional", p.Risk.MaxNotional)
+	set("max-slice-notional", p.Risk.MaxSliceNotional)
+	set("max-daily-notional", p.Risk.MaxDailyNotional)
+	set("allowed-markets", strings.Join(p.Risk.AllowedMarkets, ","))
+	set("allowed-sides", strings.Join(p.Risk.AllowedSides, ","))
+	set("notify-url", p.Notify.URL)
+	set("notify-format", p.Notify.Format)
+	set("notify-secret", p.Notify.Secret)
+	if p.RateLimit.RequestsPerSecond > 0 {
+		set("rate-limit", strconv.FormatFloat(p.RateLimit.RequestsPerSecond, 'f', -1, 64))
+	}
+	if p.RateLimit.Burst > 0 {
+		set("rate-burst", strconv.Itoa(p.RateLimit.Burst))
+	}
+	return values
+}
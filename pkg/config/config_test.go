@@ -0,0 +1,106 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+const testConfig = `
+default_profile: sandbox
+profiles:
+  sandbox:
+    base_url: https://api-sandbox.enclave.market
+    credentials: file:sandbox
+    market: AVAX-USDC
+    rate_limit:
+      requests_per_second: 2.5
+      burst: 5
+    risk:
+      max_notional: 1000
+      max_daily_notional: "2500.5"
+      allowed_sides: [buy]
+    notify:
+      url: https://hooks.slack.com/services/T0/B0/x
+      format: slack
+    defaults:
+      interval: 30s
+      market: ETH-USDC
+  production:
+    base_url: https://api.enclave.market
+`
+
+func writeConfig(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoad(t *testing.T) {
+	config, err := Load(writeConfig(t, testConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if names := config.ProfileNames(); !reflect.DeepEqual(names, []string{"production", "sandbox"}) {
+		t.Errorf("expected [production sandbox], got: %v", names)
+	}
+
+	profile, err := config.Profile("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{
+		"base-url":    "https://api-sandbox.enclave.market",
+		"credentials": "file:sandbox",
+		// The named field wins over the same flag in defaults
+		"market":     "AVAX-USDC",
+		"interval":   "30s",
+		"rate-limit": "2.5",
+		"rate-burst": "5",
+
+		"max-notional":       "1000",
+		"max-daily-notional": "2500.5",
+		"allowed-sides":      "buy",
+
+		"notify-url":    "https://hooks.slack.com/services/T0/B0/x",
+		"notify-format": "slack",
+	}
+	if values := profile.Values(); !reflect.DeepEqual(values, expected) {
+		t.Errorf("expected %v, got: %v", expected, values)
+	}
+
+	production, err := config.Profile("production")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if values := production.Values(); !reflect.DeepEqual(values, map[string]string{"base-url": "https://api.enclave.market"}) {
+		t.Errorf("unexpected production values: %v", values)
+	}
+
+	if _, err := config.Profile("staging"); err == nil {
+		t.Errorf("expected unknown profile to fail")
+	}
+}
+
+func TestLoadInvalid(t *testing.T) {
+	if _, err := Load(writeConfig(t, "default_profile: missing\nprofiles:\n  sandbox: {}\n")); err == nil {
+		t.Errorf("expected undefined default_profile to fail")
+	}
+	if _, err := Load(writeConfig(t, "profiles: [")); err == nil {
+		t.Errorf("expected invalid yaml to fail")
+	}
+	if _, err := Load(filepath.Join(t.TempDir(), "missing.yaml")); err == nil {
+		t.Errorf("expected missing file to fail")
+	}
+
+	config, err := LoadOptional(filepath.Join(t.TempDir(), "missing.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if profile, err := config.Profile(""); err != nil || len(profile.Values()) != 0 {
+		t.Errorf("expected empty profile from missing optional config, got: %v, %v", profile, err)
+	}
+}
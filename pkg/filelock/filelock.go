@@ -0,0 +1,6 @@
+package filelock
+
+import "errors"
+
+// Returned by TryLock when another process holds the lock on the file
+var ErrLocked = errors.New("file is locked by another process")
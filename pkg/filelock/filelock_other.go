@@ -0,0 +1,22 @@
+//go:build !unix
+
+package filelock
+
+import (
+	"errors"
+	"os"
+)
+
+// Files can't be locked on this platform, so anything that needs a lock fails rather than risk two processes writing
+// the same file
+func Lock(f *os.File) error {
+	return errors.ErrUnsupported
+}
+
+func TryLock(f *os.File) error {
+	return errors.ErrUnsupported
+}
+
+func Unlock(f *os.File) error {
+	return errors.ErrUnsupported
+}
@@ -0,0 +1,35 @@
+package filelock
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestTryLock(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.lock")
+	open := func() *os.File {
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { f.Close() })
+		return f
+	}
+
+	// Separate opens of the file conflict like separate processes would
+	first, second := open(), open()
+	if err := TryLock(first); err != nil {
+		t.Fatal(err)
+	}
+	if err := TryLock(second); !errors.Is(err, ErrLocked) {
+		t.Errorf("expected ErrLocked while the lock is held, got: %v", err)
+	}
+	if err := Unlock(first); err != nil {
+		t.Fatal(err)
+	}
+	if err := Lock(second); err != nil {
+		t.Errorf("expected the lock once released, got: %v", err)
+	}
+}
@@ -0,0 +1,37 @@
+//go:build unix
+
+package filelock
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// Takes an exclusive lock on the file, waiting until no other process holds it. The lock is released by Unlock or when
+// the file is closed
+func Lock(f *os.File) error {
+	return flock(f, syscall.LOCK_EX)
+}
+
+// Takes an exclusive lock on the file without waiting, returns ErrLocked if another process holds it
+func TryLock(f *os.File) error {
+	err := flock(f, syscall.LOCK_EX|syscall.LOCK_NB)
+	if errors.Is(err, syscall.EWOULDBLOCK) {
+		return ErrLocked
+	}
+	return err
+}
+
+func Unlock(f *os.File) error {
+	return flock(f, syscall.LOCK_UN)
+}
+
+func flock(f *os.File, how int) error {
+	for {
+		err := syscall.Flock(int(f.Fd()), how)
+		if !errors.Is(err, syscall.EINTR) {
+			return err
+		}
+	}
+}
@@ -1,57 +1,201 @@
 package logger
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"os"
+	"strings"
+	"sync/atomic"
 )
 
-type Logger struct {
-	info *log.Logger
-	warn *log.Logger
-	err  *log.Logger
+// The keys of fields shared across packages, so logs from a run can be queried by them
+const (
+	RunIDKey   = "run_id"
+	SliceKey   = "slice"
+	OrderIDKey = "order_id"
+	MarketKey  = "market"
+	ErrorKey   = "error"
+	TraceIDKey = "trace_id"
+)
+
+// The formats logs can be written in
+const (
+	TextFormat = "text"
+	JSONFormat = "json"
+)
+
+// How logs are written. The zero value is text at the info level. Rotate only applies to the log file
+type Options struct {
+	Level  slog.Level
+	Format string
+	Rotate RotateOptions
 }
 
-var logger *Logger
+var logger = slog.New(slog.NewTextHandler(stderr, nil))
+
+// Stderr as loggers write to it, so it can be paused while something else is drawn on the terminal
+var stderr = &pausableWriter{w: os.Stderr}
+
+type pausableWriter struct {
+	w      io.Writer
+	paused atomic.Bool
+}
 
-func SetLogger(l *Logger) {
+// Drops writes while paused, reporting them as written so other writers in a MultiWriter still get them
+func (p *pausableWriter) Write(b []byte) (int, error) {
+	if p.paused.Load() {
+		return len(b), nil
+	}
+	return p.w.Write(b)
+}
+
+// Stops loggers created by this package writing to stderr until the returned function is called, e.g. while a dashboard
+// is drawn on the terminal. Logs still go to their other writers such as the log file
+func PauseStderr() (resume func()) {
+	stderr.paused.Store(true)
+	return func() { stderr.paused.Store(false) }
+}
+
+func SetLogger(l *slog.Logger) {
 	logger = l
 }
 
-func NewFileAndStdOutLogger(fn string) (*Logger, error) {
-	file, err := os.OpenFile(fn, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
+// Returns the package logger
+func Default() *slog.Logger {
+	return logger
+}
+
+// Logs to stderr and appends to the file, which is rotated with opts.Rotate. Logs are kept off stdout so it only carries
+// command output. The file is returned to be closed before the process exits
+func NewFileAndStderrLogger(fn string, opts Options) (*slog.Logger, *RotatingFile, error) {
+	file, err := OpenRotatingFile(fn, opts.Rotate)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+	l, err := New(opts, stderr, file)
+	if err != nil {
+		file.Close()
+		return nil, nil, err
 	}
-	return New(os.Stdout, file)
+	return l, file, nil
 }
 
-func New(writers ...io.Writer) (*Logger, error) {
+// Creates a logger writing to every writer, stderr if there are none
+func New(opts Options, writers ...io.Writer) (*slog.Logger, error) {
 	if len(writers) == 0 {
-		writers = append(writers, os.Stdout)
+		writers = append(writers, stderr)
+	}
+	handler, err := newHandler(io.MultiWriter(writers...), opts)
+	if err != nil {
+		return nil, err
 	}
+	return slog.New(handler), nil
+}
+
+// Returns a logger writing to the package logger and to w, e.g. to keep a copy of a single run's logs in its own file
+func Tee(w io.Writer, opts Options) (*slog.Logger, error) {
+	handler, err := newHandler(w, opts)
+	if err != nil {
+		return nil, err
+	}
+	return slog.New(teeHandler{logger.Handler(), handler}), nil
+}
 
-	fn := os.Getenv("LOG_FILE")
-	if fn == "" {
-		fn = "app.log"
+func newHandler(w io.Writer, opts Options) (slog.Handler, error) {
+	handlerOpts := &slog.HandlerOptions{Level: opts.Level}
+	switch opts.Format {
+	case TextFormat, "":
+		return slog.NewTextHandler(w, handlerOpts), nil
+	case JSONFormat:
+		return slog.NewJSONHandler(w, handlerOpts), nil
 	}
+	return nil, fmt.Errorf("log format must be text or json, received: %s", opts.Format)
+}
+
+// Sends each record to every handler that is enabled for its level
+type teeHandler []slog.Handler
+
+func (t teeHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	for _, h := range t {
+		if h.Enabled(ctx, level) {
+			return true
+		}
+	}
+	return false
+}
+
+func (t teeHandler) Handle(ctx context.Context, r slog.Record) error {
+	var errs []error
+	for _, h := range t {
+		if h.Enabled(ctx, r.Level) {
+			if err := h.Handle(ctx, r.Clone()); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (t teeHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	handlers := make(teeHandler, len(t))
+	for i, h := range t {
+		handlers[i] = h.WithAttrs(attrs)
+	}
+	return handlers
+}
+
+func (t teeHandler) WithGroup(name string) slog.Handler {
+	handlers := make(teeHandler, len(t))
+	for i, h := range t {
+		handlers[i] = h.WithGroup(name)
+	}
+	return handlers
+}
+
+// Parses debug, info, warn or error
+func ParseLevel(level string) (slog.Level, error) {
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(strings.ToLower(level))); err != nil {
+		return l, fmt.Errorf("log level must be debug, info, warn or error, received: %s", level)
+	}
+	return l, nil
+}
+
+type contextKey struct{}
+
+// Returns a context carrying the logger, so functions it is passed to log with its fields
+func NewContext(ctx context.Context, l *slog.Logger) context.Context {
+	return context.WithValue(ctx, contextKey{}, l)
+}
+
+// Returns the logger carried by the context, or the package logger if there isn't one
+func FromContext(ctx context.Context) *slog.Logger {
+	if l, ok := ctx.Value(contextKey{}).(*slog.Logger); ok {
+		return l
+	}
+	return logger
+}
+
+// Returns the package logger with the fields added to every record
+func With(args ...any) *slog.Logger {
+	return logger.With(args...)
+}
 
-	mw := io.MultiWriter(writers...)
-	return &Logger{
-		info: log.New(mw, "INFO: ", log.LstdFlags),
-		warn: log.New(mw, "WARN: ", log.LstdFlags),
-		err:  log.New(mw, "ERROR: ", log.LstdFlags),
-	}, nil
+func Debug(msg string, args ...any) {
+	logger.Debug(msg, args...)
 }
 
-func Info(v ...interface{}) {
-	logger.info.Println(v...)
+func Info(msg string, args ...any) {
+	logger.Info(msg, args...)
 }
 
-func Warn(v ...interface{}) {
-	logger.warn.Println(v...)
+func Warn(msg string, args ...any) {
+	logger.Warn(msg, args...)
 }
 
-func Error(v ...interface{}) {
-	logger.err.Println(v...)
+func Error(msg string, args ...any) {
+	logger.Error(msg, args...)
 }
@@ -0,0 +1,98 @@
+package logger
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestParseLevel(t *testing.T) {
+	for level, expected := range map[string]slog.Level{"debug": slog.LevelDebug, "INFO": slog.LevelInfo, "warn": slog.LevelWarn, "error": slog.LevelError} {
+		if l, err := ParseLevel(level); err != nil || l != expected {
+			t.Errorf("%s: expected %s, got %s, %v", level, expected, l, err)
+		}
+	}
+	if _, err := ParseLevel("verbose"); err == nil {
+		t.Errorf("expected error, got nil")
+	}
+}
+
+func TestNew(t *testing.T) {
+	buf := &bytes.Buffer{}
+	l, err := New(Options{Level: slog.LevelWarn, Format: JSONFormat}, buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Info("filtered")
+	l.Warn("kept", RunIDKey, "abc", SliceKey, 3)
+
+	record := map[string]any{}
+	if err := json.Unmarshal(buf.Bytes(), &record); err != nil {
+		t.Fatalf("expected a single JSON record, got %q: %v", buf.String(), err)
+	}
+	if record["msg"] != "kept" || record[RunIDKey] != "abc" || record[SliceKey] != 3.0 {
+		t.Errorf("unexpected record: %v", record)
+	}
+
+	if _, err := New(Options{Format: "xml"}, buf); err == nil {
+		t.Errorf("expected error, got nil")
+	}
+}
+
+func TestFromContext(t *testing.T) {
+	buf := &bytes.Buffer{}
+	l, _ := New(Options{}, buf)
+	SetLogger(l)
+
+	FromContext(context.Background()).Info("package")
+	FromContext(NewContext(context.Background(), With(MarketKey, "AVAX-USDC"))).Info("carried")
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 || strings.Contains(lines[0], MarketKey) || !strings.Contains(lines[1], "market=AVAX-USDC") {
+		t.Errorf("expected the market on the carried logger only, got: %q", lines)
+	}
+}
+
+func TestTee(t *testing.T) {
+	main, run := &bytes.Buffer{}, &bytes.Buffer{}
+	l, _ := New(Options{Level: slog.LevelInfo}, main)
+	SetLogger(l)
+
+	// The run's copy is at the debug level, the package logger's level still applies to it
+	tee, err := Tee(run, Options{Level: slog.LevelDebug, Format: JSONFormat})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tee.With(RunIDKey, "abc").Debug("debug only")
+	tee.With(RunIDKey, "abc").Info("both")
+
+	if strings.Contains(main.String(), "debug only") || !strings.Contains(main.String(), "run_id=abc") {
+		t.Errorf("unexpected package log: %q", main.String())
+	}
+	lines := strings.Split(strings.TrimSpace(run.String()), "\n")
+	if len(lines) != 2 || !strings.Contains(lines[1], `"run_id":"abc"`) {
+		t.Errorf("unexpected run log: %q", lines)
+	}
+}
+
+func TestPauseStderr(t *testing.T) {
+	terminal, file := &bytes.Buffer{}, &bytes.Buffer{}
+	original := stderr.w
+	stderr.w = terminal
+	defer func() { stderr.w = original }()
+
+	l, _ := New(Options{}, stderr, file)
+	resume := PauseStderr()
+	l.Info("paused")
+	resume()
+	l.Info("resumed")
+
+	if strings.Contains(terminal.String(), "paused") || !strings.Contains(terminal.String(), "resumed") {
+		t.Errorf("unexpected stderr: %q", terminal.String())
+	}
+	if !strings.Contains(file.String(), "paused") || !strings.Contains(file.String(), "resumed") {
+		t.Errorf("expected the file to get every log, got: %q", file.String())
+	}
+}
@@ -0,0 +1,273 @@
+package logger
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The timestamp rotated files are named with, sorts in the order they were rotated
+const backupTimeFormat = "20060102T150405.000Z"
+
+// How long writes carry on to the current file after it fails to rotate, before rotating is tried again
+const rotateRetryBackoff = time.Minute
+
+// Replaced in tests to make rotating fail
+var rename = os.Rename
+
+// Where writes go while the file can't be opened, replaced in tests
+var fallback io.Writer = os.Stderr
+
+// When a log file is rotated and how many rotated files are kept. The zero value never rotates
+type RotateOptions struct {
+	// Rotate before the file grows past this many bytes, never if 0
+	MaxSize int64
+	// Rotate on the first write in a new period of this length, e.g. 24h for daily at midnight UTC. Never if 0
+	Every time.Duration
+	// How many rotated files are kept, the oldest are deleted. All of them if 0
+	MaxBackups int
+	// Gzip rotated files
+	Compress bool
+}
+
+// A log file that is renamed with a timestamp, e.g. app-20240501T120000.000Z.log, and started again when it is rotated.
+// Safe to write to from multiple goroutines
+type RotatingFile struct {
+	path string
+	opts RotateOptions
+
+	mu sync.Mutex
+	// nil if it couldn't be opened again after rotating, writes go to fallback until it can be
+	file   *os.File
+	size   int64
+	period time.Time
+	// Rotating isn't tried again before this after it fails
+	retryAt time.Time
+	// Whether the file couldn't be reopened, so the error is only reported once
+	reopenFailed bool
+	closed       bool
+
+	// Compressing and pruning rotated files runs in the background one at a time
+	cleanMu sync.Mutex
+	wg      sync.WaitGroup
+}
+
+// Opens the file for appending, creating it if it doesn't exist
+func OpenRotatingFile(path string, opts RotateOptions) (*RotatingFile, error) {
+	f := &RotatingFile{path: path, opts: opts}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *RotatingFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	// A file left by an earlier run belongs to the period it was last written in
+	f.file, f.size, f.period = file, info.Size(), f.periodOf(info.ModTime())
+	return nil
+}
+
+func (f *RotatingFile) periodOf(t time.Time) time.Time {
+	if f.opts.Every <= 0 {
+		return time.Time{}
+	}
+	return t.Truncate(f.opts.Every)
+}
+
+func (f *RotatingFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.closed {
+		return 0, os.ErrClosed
+	}
+
+	now := time.Now()
+	full := f.opts.MaxSize > 0 && f.size+int64(len(p)) > f.opts.MaxSize
+	expired := f.opts.Every > 0 && !f.periodOf(now).Equal(f.period)
+	if f.file != nil && f.size > 0 && (full || expired) && !now.Before(f.retryAt) {
+		// The write still goes to the current file rather than being lost
+		if err := f.rotate(now); err != nil {
+			f.retryAt = now.Add(rotateRetryBackoff)
+			fmt.Fprintf(os.Stderr, "%v, trying again in %s\n", err, rotateRetryBackoff)
+		}
+	}
+
+	// A file left closed by a failed rotation is opened again, and until it can be the logs go to stderr
+	if f.file == nil {
+		if err := f.open(); err != nil {
+			if !f.reopenFailed {
+				fmt.Fprintf(os.Stderr, "unable to reopen %s, writing logs to stderr until it can be: %v\n", f.path, err)
+				f.reopenFailed = true
+			}
+			return fallback.Write(p)
+		}
+		f.reopenFailed = false
+	}
+
+	// A file that couldn't be rotated stays in its period, so rotating it is tried again after the backoff
+	if f.size == 0 || !expired {
+		f.period = f.periodOf(now)
+	}
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+// Rotates the file now, regardless of its size and age
+func (f *RotatingFile) Rotate() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.rotate(time.Now())
+}
+
+// Closes the file once rotated files have been compressed and pruned
+func (f *RotatingFile) Close() error {
+	f.mu.Lock()
+	var err error
+	if f.file != nil {
+		err = f.file.Close()
+		f.file = nil
+	}
+	f.closed = true
+	f.mu.Unlock()
+	f.wg.Wait()
+	return err
+}
+
+// Renames the file and opens a new one. The file can't be written to once closed, even if closing fails, so if it isn't
+// opened again the next write tries to
+func (f *RotatingFile) rotate(now time.Time) error {
+	if f.file != nil {
+		err := f.file.Close()
+		f.file = nil
+		if err != nil {
+			return err
+		}
+	}
+	backup := f.backupName(now)
+	if err := rename(f.path, backup); err != nil {
+		// Keep logging to the same file rather than losing logs
+		if openErr := f.open(); openErr != nil {
+			return openErr
+		}
+		return fmt.Errorf("unable to rotate %s: %w", f.path, err)
+	}
+	if err := f.open(); err != nil {
+		return err
+	}
+	f.wg.Add(1)
+	go f.cleanUp(backup)
+	return nil
+}
+
+// Compresses the rotated file and deletes the oldest. They only affect old logs, so they run off the write path and
+// failures are reported on stderr
+func (f *RotatingFile) cleanUp(backup string) {
+	defer f.wg.Done()
+	f.cleanMu.Lock()
+	defer f.cleanMu.Unlock()
+
+	if f.opts.Compress {
+		if err := compress(backup); err != nil {
+			fmt.Fprintf(os.Stderr, "unable to compress %s: %v\n", backup, err)
+		}
+	}
+	if err := f.prune(); err != nil {
+		fmt.Fprintf(os.Stderr, "unable to remove old logs of %s: %v\n", f.path, err)
+	}
+}
+
+// The name the file is renamed to, the extension is kept so app.log becomes app-<timestamp>.log
+func (f *RotatingFile) backupName(t time.Time) string {
+	ext := filepath.Ext(f.path)
+	return strings.TrimSuffix(f.path, ext) + "-" + t.UTC().Format(backupTimeFormat) + ext
+}
+
+// Returns the rotated files of this log, oldest first
+func (f *RotatingFile) backups() ([]string, error) {
+	ext := filepath.Ext(f.path)
+	prefix := filepath.Base(strings.TrimSuffix(f.path, ext)) + "-"
+	entries, err := os.ReadDir(filepath.Dir(f.path))
+	if err != nil {
+		return nil, err
+	}
+
+	backups := []string{}
+	for _, entry := range entries {
+		name := strings.TrimSuffix(entry.Name(), ".gz")
+		if entry.IsDir() || !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, ext) {
+			continue
+		}
+		timestamp := strings.TrimSuffix(strings.TrimPrefix(name, prefix), ext)
+		if _, err := time.Parse(backupTimeFormat, timestamp); err != nil {
+			continue
+		}
+		backups = append(backups, filepath.Join(filepath.Dir(f.path), entry.Name()))
+	}
+	sort.Strings(backups)
+	return backups, nil
+}
+
+// Deletes the oldest rotated files past the retention count
+func (f *RotatingFile) prune() error {
+	if f.opts.MaxBackups <= 0 {
+		return nil
+	}
+	backups, err := f.backups()
+	if err != nil {
+		return err
+	}
+	for len(backups) > f.opts.MaxBackups {
+		if err := os.Remove(backups[0]); err != nil {
+			return err
+		}
+		backups = backups[1:]
+	}
+	return nil
+}
+
+// Gzips the file to path.gz and removes the original
+func compress(path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.OpenFile(path+".gz", os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0666)
+	if err != nil {
+		return err
+	}
+	gz := gzip.NewWriter(dst)
+	if _, err := io.Copy(gz, src); err != nil {
+		dst.Close()
+		os.Remove(path + ".gz")
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		dst.Close()
+		os.Remove(path + ".gz")
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		return err
+	}
+	src.Close()
+	return os.Remove(path)
+}
@@ -0,0 +1,168 @@
+package logger
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRotateOnSize(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+	f, err := OpenRotatingFile(path, RotateOptions{MaxSize: 10, MaxBackups: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	// Each write after the first would take the file past 10 bytes. Only the two newest rotated files are kept
+	for _, line := range []string{"first\n", "second\n", "third\n", "fourth\n"} {
+		if _, err := f.Write([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(2 * time.Millisecond)
+	}
+
+	if b, _ := os.ReadFile(path); string(b) != "fourth\n" {
+		t.Errorf("expected the current file to hold the last write, got %q", b)
+	}
+	f.wg.Wait()
+	backups, _ := f.backups()
+	if len(backups) != 2 {
+		t.Fatalf("expected 2 backups, got: %v", backups)
+	}
+	for i, expected := range []string{"second\n", "third\n"} {
+		if b, _ := os.ReadFile(backups[i]); string(b) != expected {
+			t.Errorf("expected backup %d to hold %q, got %q", i, expected, b)
+		}
+		if !strings.HasPrefix(filepath.Base(backups[i]), "app-") || filepath.Ext(backups[i]) != ".log" {
+			t.Errorf("unexpected backup name: %s", backups[i])
+		}
+	}
+}
+
+func TestRotateOnTime(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+	os.WriteFile(path, []byte("yesterday\n"), 0666)
+	yesterday := time.Now().Add(-24 * time.Hour)
+	os.Chtimes(path, yesterday, yesterday)
+
+	f, err := OpenRotatingFile(path, RotateOptions{Every: 24 * time.Hour, Compress: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	// The file left from yesterday is rotated on the first write, a second write today isn't
+	f.Write([]byte("today\n"))
+	f.Write([]byte("again\n"))
+	if b, _ := os.ReadFile(path); string(b) != "today\nagain\n" {
+		t.Errorf("expected today's writes, got %q", b)
+	}
+
+	f.wg.Wait()
+	backups, _ := f.backups()
+	if len(backups) != 1 || !strings.HasSuffix(backups[0], ".log.gz") {
+		t.Fatalf("expected a compressed backup, got: %v", backups)
+	}
+	file, _ := os.Open(backups[0])
+	defer file.Close()
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := io.ReadAll(gz); string(b) != "yesterday\n" {
+		t.Errorf("expected yesterday's log in the backup, got %q", b)
+	}
+}
+
+func TestRotateFailure(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+	f, err := OpenRotatingFile(path, RotateOptions{MaxSize: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	renames := 0
+	rename = func(oldpath, newpath string) error {
+		renames++
+		return errors.New("rename failed")
+	}
+	defer func() { rename = os.Rename }()
+
+	// Writes carry on to the file that couldn't be rotated, and rotating isn't tried again until the backoff is over
+	for _, line := range []string{"first\n", "second\n", "third\n"} {
+		if n, err := f.Write([]byte(line)); n != len(line) || err != nil {
+			t.Fatalf("expected %q to be written, got %d, %v", line, n, err)
+		}
+	}
+	if b, _ := os.ReadFile(path); string(b) != "first\nsecond\nthird\n" || renames != 1 {
+		t.Errorf("expected every write in the file after 1 rename, got %q after %d", b, renames)
+	}
+
+	rename = os.Rename
+	f.retryAt = time.Time{}
+	f.Write([]byte("fourth\n"))
+	f.wg.Wait()
+	if backups, _ := f.backups(); len(backups) != 1 {
+		t.Errorf("expected the file to rotate once the backoff is over, got: %v", backups)
+	}
+}
+
+func TestRotateReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+	f, err := OpenRotatingFile(path, RotateOptions{MaxSize: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	// The file is renamed but a directory in its place stops a new one being opened
+	rename = func(oldpath, newpath string) error {
+		if err := os.Rename(oldpath, newpath); err != nil {
+			return err
+		}
+		return os.Mkdir(oldpath, 0700)
+	}
+	defer func() { rename = os.Rename }()
+	buf := &bytes.Buffer{}
+	fallback = buf
+	defer func() { fallback = os.Stderr }()
+
+	f.Write([]byte("first\n"))
+	if n, err := f.Write([]byte("second\n")); n != 7 || err != nil || buf.String() != "second\n" {
+		t.Errorf("expected the write to go to stderr, got %d, %v, %q", n, err, buf.String())
+	}
+
+	// Once it can be opened again writes go back to the file
+	os.Remove(path)
+	f.Write([]byte("third\n"))
+	if b, _ := os.ReadFile(path); string(b) != "third\n" || buf.String() != "second\n" {
+		t.Errorf("expected the file to be reopened, got %q and %q on stderr", b, buf.String())
+	}
+
+	f.Close()
+	if _, err := f.Write([]byte("fourth\n")); !errors.Is(err, os.ErrClosed) {
+		t.Errorf("expected writes after closing to fail, got: %v", err)
+	}
+}
+
+func TestNoRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+	f, err := OpenRotatingFile(path, RotateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	f.Write([]byte(strings.Repeat("x", 1000)))
+	f.Write([]byte("y"))
+	if backups, _ := f.backups(); len(backups) != 0 {
+		t.Errorf("expected no backups, got: %v", backups)
+	}
+}
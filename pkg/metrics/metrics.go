@@ -0,0 +1,26 @@
+package metrics
+
+import (
+	"net"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Serves the metrics in the default Prometheus registry at /metrics, including the Go runtime and process metrics
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
+
+// Starts serving /metrics on addr in the background. Returns once the address is listened on, so a port already in use
+// is reported to the caller
+func Serve(addr string) (net.Addr, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", Handler())
+	go http.Serve(listener, mux)
+	return listener.Addr(), nil
+}
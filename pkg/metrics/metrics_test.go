@@ -0,0 +1,39 @@
+package metrics
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+func TestServe(t *testing.T) {
+	counter := promauto.NewCounter(prometheus.CounterOpts{Name: "test_serve_total", Help: "A test counter"})
+	counter.Inc()
+
+	addr, err := Serve("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := http.Get("http://" + addr.String() + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	body, _ := io.ReadAll(res.Body)
+	if !strings.HasPrefix(res.Header.Get("Content-Type"), "text/plain") {
+		t.Errorf("unexpected content type %s", res.Header.Get("Content-Type"))
+	}
+	for _, line := range []string{"test_serve_total 1\n", "go_goroutines "} {
+		if !strings.Contains(string(body), line) {
+			t.Errorf("expected %q in the response, got:\n%s", line, body)
+		}
+	}
+
+	if _, err := Serve(addr.String()); err == nil {
+		t.Errorf("expected an error serving on an address in use")
+	}
+}
@@ -0,0 +1,24 @@
+package tracing
+
+import (
+	"context"
+	"io"
+
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// The collector's OTLP/HTTP traces endpoint when it runs locally with its default settings
+const DefaultOTLPEndpoint = "http://localhost:4318/v1/traces"
+
+// Writes each span to w as a line of JSON
+func NewWriterExporter(w io.Writer) (sdktrace.SpanExporter, error) {
+	return stdouttrace.New(stdouttrace.WithWriter(w))
+}
+
+// Sends spans to an OTLP/HTTP collector at endpoint, e.g. DefaultOTLPEndpoint. The endpoint's scheme decides whether
+// TLS is used
+func NewOTLPExporter(ctx context.Context, endpoint string) (sdktrace.SpanExporter, error) {
+	return otlptracehttp.New(ctx, otlptracehttp.WithEndpointURL(endpoint))
+}
@@ -0,0 +1,80 @@
+package tracing
+
+import (
+	"context"
+	"sync"
+
+	"github.com/garry-sharp/enclave-assessment/pkg/logger"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// The instrumentation scope spans are created under
+const scope = "github.com/garry-sharp/enclave-assessment"
+
+var (
+	mu       sync.Mutex
+	provider *sdktrace.TracerProvider
+)
+
+// Records spans with the processor from now on, e.g. a batch processor in front of an exporter, with service.name set
+// to service. The W3C traceparent header is propagated on outgoing requests. Call once, before any requests are sent, as
+// the HTTP client's instrumentation keeps the first provider it is given
+func Setup(service string, processor sdktrace.SpanProcessor) {
+	p := sdktrace.NewTracerProvider(
+		sdktrace.WithSpanProcessor(processor),
+		sdktrace.WithResource(resource.NewSchemaless(semconv.ServiceName(service))),
+	)
+	mu.Lock()
+	provider = p
+	mu.Unlock()
+
+	otel.SetTracerProvider(p)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	// Spans the collector doesn't accept are dropped rather than holding up the run
+	otel.SetErrorHandler(otel.ErrorHandlerFunc(func(err error) {
+		logger.Warn("unable to export spans", logger.ErrorKey, err)
+	}))
+}
+
+// Sends the spans that haven't been exported yet and stops recording them. Call before the process exits
+func Shutdown(ctx context.Context) error {
+	mu.Lock()
+	p := provider
+	provider = nil
+	mu.Unlock()
+	if p == nil {
+		return nil
+	}
+	return p.Shutdown(ctx)
+}
+
+// Starts a span as a child of the context's span, or as the root of a new trace if it has none, and returns a context
+// carrying it. The span doesn't record anything until Setup is called
+func Start(ctx context.Context, name string, attributes ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(scope).Start(ctx, name, trace.WithAttributes(attributes...))
+}
+
+// Marks the span as failed, nil errors are ignored
+func RecordError(span trace.Span, err error) {
+	if err == nil {
+		return
+	}
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
+
+// The trace the span belongs to, empty if it isn't recorded
+func TraceID(span trace.Span) string {
+	if !span.SpanContext().HasTraceID() {
+		return ""
+	}
+	return span.SpanContext().TraceID().String()
+}
@@ -0,0 +1,114 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestStart(t *testing.T) {
+	ctx, span := Start(context.Background(), "off")
+	if span.IsRecording() || TraceID(span) != "" {
+		t.Fatalf("expected no span recorded before Setup")
+	}
+	RecordError(span, errors.New("ignored"))
+	span.End()
+
+	recorder := tracetest.NewSpanRecorder()
+	Setup("enclave-twap", recorder)
+	defer Shutdown(context.Background())
+
+	ctx, parent := Start(context.Background(), "parent", attribute.Int("slice", 3))
+	_, child := Start(ctx, "child")
+	RecordError(child, errors.New("rejected"))
+	RecordError(parent, nil)
+	child.End()
+	parent.End()
+
+	spans := recorder.Ended()
+	if len(spans) != 2 {
+		t.Fatalf("expected 2 spans, got %d", len(spans))
+	}
+	c, p := spans[0], spans[1]
+	if c.SpanContext().TraceID() != p.SpanContext().TraceID() || c.Parent().SpanID() != p.SpanContext().SpanID() || p.Parent().IsValid() {
+		t.Errorf("child isn't linked to its parent")
+	}
+	if c.Status().Code != codes.Error || c.Status().Description != "rejected" || p.Status().Code != codes.Unset {
+		t.Errorf("unexpected status: %+v, %+v", c.Status(), p.Status())
+	}
+	if len(p.Attributes()) != 1 || p.Attributes()[0] != attribute.Int("slice", 3) || TraceID(parent) != p.SpanContext().TraceID().String() {
+		t.Errorf("unexpected span: %v", p.Attributes())
+	}
+	if service, _ := p.Resource().Set().Value("service.name"); service.AsString() != "enclave-twap" {
+		t.Errorf("unexpected service name: %v", service)
+	}
+}
+
+func TestWriterExporter(t *testing.T) {
+	buf := &bytes.Buffer{}
+	exporter, err := NewWriterExporter(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	Setup("enclave-twap", sdktrace.NewSimpleSpanProcessor(exporter))
+	_, span := Start(context.Background(), "twap.run", attribute.String("market", "AVAX-USDC"))
+	span.End()
+	if err := Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	record := map[string]any{}
+	if err := json.Unmarshal(buf.Bytes(), &record); err != nil {
+		t.Fatalf("expected a JSON line, got %q: %v", buf.String(), err)
+	}
+	if record["Name"] != "twap.run" {
+		t.Errorf("unexpected span: %v", record)
+	}
+	if _, span := Start(context.Background(), "after shutdown"); span.IsRecording() {
+		t.Errorf("expected tracing to be off after shutdown")
+	}
+}
+
+func TestOTLPExporter(t *testing.T) {
+	requests := make(chan int, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if r.URL.Path != "/v1/traces" || r.Header.Get("Content-Type") != "application/x-protobuf" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		requests <- len(body)
+	}))
+	defer server.Close()
+
+	exporter, err := NewOTLPExporter(context.Background(), server.URL+"/v1/traces")
+	if err != nil {
+		t.Fatal(err)
+	}
+	Setup("enclave-twap", sdktrace.NewBatchSpanProcessor(exporter))
+	_, span := Start(context.Background(), "twap.run")
+	span.End()
+
+	// Batched spans are sent on shutdown
+	if err := Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case size := <-requests:
+		if size == 0 {
+			t.Errorf("expected spans in the request")
+		}
+	default:
+		t.Errorf("expected the spans to be sent to the collector")
+	}
+}
@@ -0,0 +1,179 @@
+package twap
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/garry-sharp/enclave-assessment/pkg/api"
+	"github.com/garry-sharp/enclave-assessment/pkg/logger"
+)
+
+// What to do when a slice is rejected for insufficient funds part way through a run
+type BalancePolicy string
+
+const (
+	// Stop placing slices and finish the run with what has been filled so far
+	ABORT BalancePolicy = "abort"
+	// Shrink the remaining slices so they fit in the balance that is left
+	SHRINK BalancePolicy = "shrink"
+	// Pause placing slices until the balance is topped up again
+	WAIT BalancePolicy = "wait"
+)
+
+const balancePollInterval = 5 * time.Second
+
+// A decision made by the balance policy, recorded in the report
+type BalanceDecision struct {
+	Iteration int           `json:"iteration"`
+	Timestamp time.Time     `json:"timestamp"`
+	Policy    BalancePolicy `json:"policy"`
+	Available *big.Float    `json:"available"`
+	Action    string        `json:"action"`
+}
+
+// Parses the balance policy, defaults to abort
+func ParseBalancePolicy(policy string) (BalancePolicy, error) {
+	switch BalancePolicy(strings.ToLower(policy)) {
+	case "", ABORT:
+		return ABORT, nil
+	case SHRINK:
+		return SHRINK, nil
+	case WAIT:
+		return WAIT, nil
+	}
+	return "", fmt.Errorf("balance-policy must be one of abort, shrink or wait, received: %s", policy)
+}
+
+// Applies the balance policy after slice i was rejected for insufficient funds. Returns the quantity to retry the slice
+// with, or false if the run should stop
+func (e *execution) handleInsufficientFunds(i int, qty *big.Float) (*big.Float, bool) {
+	available, err := e.availableBalance()
+	if err != nil {
+		e.log.Error("unable to re-check balance", logger.SliceKey, i, logger.ErrorKey, err)
+		e.recordBalanceDecision(i, nil, "balance unavailable, aborting")
+		return nil, false
+	}
+	e.log.Warn("insufficient funds", logger.SliceKey, i, "available", available, "requested", qty)
+
+	switch e.balancePolicy {
+	case SHRINK:
+		// Spread what is left evenly over this slice and the ones not yet placed. The other slices in flight will spend
+		// some of the balance, so their quantities are kept back along with the fee reserve
+		remaining := e.slices - int(e.placed.Load()) + 1
+		e.mu.Lock()
+		others := new(big.Float).Sub(e.inFlight, qty)
+		size := ShrinkSlice(available, others, e.feeReserve, e.increment, remaining)
+		if size.Cmp(e.minimum) < 0 {
+			e.mu.Unlock()
+			e.recordBalanceDecision(i, available, fmt.Sprintf("%s is below the minimum order size of %s, aborting", size.String(), e.minimum.String()))
+			return nil, false
+		}
+		if size.Cmp(qty) > 0 {
+			size = qty
+		}
+		e.sliceCap = size
+		e.inFlight.Add(others, size)
+		e.mu.Unlock()
+		e.recordBalanceDecision(i, available, fmt.Sprintf("shrinking %d remaining slices to %s", remaining, size.String()))
+		return size, true
+
+	case WAIT:
+		e.paused.Add(1)
+		defer e.paused.Add(-1)
+		e.recordBalanceDecision(i, available, fmt.Sprintf("pausing for up to %s until %s is available", e.balanceWait, qty.String()))
+
+		deadline := time.Now().Add(e.balanceWait)
+		for time.Now().Before(deadline) {
+			select {
+			case <-e.ctx.Done():
+				return nil, false
+			case <-time.After(balancePollInterval):
+			}
+			available, err = e.availableBalance()
+			if err == nil && available.Cmp(qty) >= 0 {
+				e.recordBalanceDecision(i, available, "balance restored, resuming")
+				return qty, true
+			}
+		}
+		e.recordBalanceDecision(i, available, "balance not restored in time, aborting")
+		return nil, false
+	}
+
+	e.recordBalanceDecision(i, available, "aborting")
+	return nil, false
+}
+
+// The size to shrink each of the remaining slices to so they fit in the available balance, rounded down to the increment.
+// inFlight is held by other slices still being placed, and feeReserve is the fraction kept back for fees, nil for none
+func ShrinkSlice(available, inFlight, feeReserve, increment *big.Float, remaining int) *big.Float {
+	spendable := new(big.Float).Set(available)
+	if feeReserve != nil {
+		spendable.Quo(spendable, new(big.Float).Add(big.NewFloat(1), feeReserve))
+	}
+	spendable.Sub(spendable, inFlight)
+	if spendable.Sign() <= 0 || remaining <= 0 {
+		return big.NewFloat(0)
+	}
+	return RoundDown(spendable.Quo(spendable, big.NewFloat(float64(remaining))), increment)
+}
+
+// Holds the quantity of a slice being placed until release, so shrinking leaves it out of the balance
+func (e *execution) hold(qty *big.Float) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.inFlight.Add(e.inFlight, qty)
+}
+
+func (e *execution) release(qty *big.Float) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.inFlight.Sub(e.inFlight, qty)
+}
+
+// Returns the free balance of the asset being spent, converted to the denomination the slices are sized in
+func (e *execution) availableBalance() (*big.Float, error) {
+	ctx, cancel := context.WithTimeout(e.ctx, 5*time.Second)
+	defer cancel()
+
+	balance := api.APIResponse[api.GetBalanceResponse]{}
+	if err := api.GetBalance(ctx, e.balanceAsset, &balance); err != nil {
+		return nil, err
+	}
+	free, ok := big.NewFloat(0).SetString(balance.Result.FreeBalance)
+	if !ok {
+		return nil, fmt.Errorf("unable to parse balance")
+	}
+	if e.spendDenomination == e.denomination {
+		return free, nil
+	}
+
+	price, err := api.GetMidPrice(ctx, e.market)
+	if err != nil {
+		return nil, err
+	}
+	return ConvertAmount(free, price, e.spendDenomination, e.denomination)
+}
+
+// Caps a slice to the size set by the shrink policy, if any
+func (e *execution) capSlice(qty *big.Float) *big.Float {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.sliceCap != nil && qty.Cmp(e.sliceCap) > 0 {
+		return new(big.Float).Set(e.sliceCap)
+	}
+	return qty
+}
+
+func (e *execution) recordBalanceDecision(i int, available *big.Float, action string) {
+	e.log.Warn("balance policy decision", logger.SliceKey, i, "policy", e.balancePolicy, "action", action)
+	e.report.AddBalanceDecision(BalanceDecision{
+		Iteration: i,
+		Timestamp: time.Now(),
+		Policy:    e.balancePolicy,
+		Available: available,
+		Action:    action,
+	})
+}
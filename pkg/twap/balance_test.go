@@ -0,0 +1,67 @@
+package twap
+
+import (
+	"math/big"
+	"testing"
+	"time"
+)
+
+func TestParseBalancePolicy(t *testing.T) {
+	tests := map[string]BalancePolicy{"": ABORT, "abort": ABORT, "SHRINK": SHRINK, "wait": WAIT}
+	for value, expected := range tests {
+		policy, err := ParseBalancePolicy(value)
+		if err != nil || policy != expected {
+			t.Errorf("expected %s for %q, got: %s, %v", expected, value, policy, err)
+		}
+	}
+
+	if _, err := ParseBalancePolicy("retry"); err == nil {
+		t.Errorf("expected error, got nil")
+	}
+}
+
+func TestParseBalanceWait(t *testing.T) {
+	res1, err1 := ParseBalanceWait("")
+	res2, err2 := ParseBalanceWait("30s")
+	_, err3 := ParseBalanceWait("0s")
+	if err1 != nil || err2 != nil || err3 == nil {
+		t.Errorf("unexpected errors: %v, %v, %v", err1, err2, err3)
+	}
+	if res1 != 5*time.Minute || res2 != 30*time.Second {
+		t.Errorf("unexpected values: %s, %s", res1, res2)
+	}
+}
+
+func TestCapSlice(t *testing.T) {
+	e := &execution{}
+	if e.capSlice(big.NewFloat(10)).String() != "10" {
+		t.Errorf("expected uncapped slice")
+	}
+
+	e.sliceCap = big.NewFloat(4)
+	if e.capSlice(big.NewFloat(10)).String() != "4" || e.capSlice(big.NewFloat(3)).String() != "3" {
+		t.Errorf("expected slice capped at 4")
+	}
+}
+
+func TestShrinkSlice(t *testing.T) {
+	tests := []struct {
+		name       string
+		available  float64
+		inFlight   float64
+		feeReserve *big.Float
+		remaining  int
+		expected   string
+	}{
+		{name: "Spread", available: 100, remaining: 3, expected: "33.25"},
+		{name: "In flight", available: 100, inFlight: 40, remaining: 3, expected: "20"},
+		{name: "Fee reserve", available: 125, feeReserve: big.NewFloat(0.25), remaining: 2, expected: "50"},
+		{name: "Nothing left", available: 30, inFlight: 40, remaining: 2, expected: "0"},
+	}
+	for _, tt := range tests {
+		size := ShrinkSlice(big.NewFloat(tt.available), big.NewFloat(tt.inFlight), tt.feeReserve, big.NewFloat(0.25), tt.remaining)
+		if size.Text('f', -1) != tt.expected {
+			t.Errorf("%s: expected %s, got: %s", tt.name, tt.expected, size.Text('f', -1))
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package twap
+
+import (
+	"math/big"
+	"time"
+)
+
+// The kind of an Event. The values are part of the JSON output and shouldn't be changed
+type EventType string
+
+const (
+	// The plan was confirmed and the first slice is about to be placed
+	EventStarted EventType = "started"
+	// A slice was skipped on this tick, Reason says why
+	EventSliceDeferred EventType = "slice_deferred"
+	// A slice's order is being sent
+	EventSlicePlaced EventType = "slice_placed"
+	// A slice's order failed with an error that will be retried after RetryAfterMs
+	EventSliceRetry EventType = "slice_retry"
+	// A slice's order was accepted, the fill and the progress of the run are set
+	EventSliceFilled EventType = "slice_filled"
+	// A slice's order failed and won't be retried
+	EventSliceFailed EventType = "slice_failed"
+	// The run was stopped early, the remaining slices won't be placed
+	EventAborted EventType = "aborted"
+	// All slices have finished, successfully or not. The report is returned by ExecuteTwap
+	EventCompleted EventType = "completed"
+)
+
+// A progress update from a running TWAP. Fields that don't apply to the type are left empty. Executed, Target and
+// CompletionPercent are the progress of the whole run in the amount's denomination, set on every event after started
+type Event struct {
+	Type              EventType  `json:"type"`
+	Time              time.Time  `json:"time"`
+	RunID             string     `json:"runId"`
+	Market            string     `json:"market"`
+	Side              string     `json:"side"`
+	Slice             *int       `json:"slice,omitempty"`
+	OrderId           string     `json:"orderId,omitempty"`
+	Requested         *big.Float `json:"requested,omitempty"`
+	Size              *big.Float `json:"size,omitempty"`
+	Cost              *big.Float `json:"cost,omitempty"`
+	Price             *big.Float `json:"price,omitempty"`
+	Fee               *big.Float `json:"fee,omitempty"`
+	Executed          *big.Float `json:"executed,omitempty"`
+	Target            *big.Float `json:"target,omitempty"`
+	CompletionPercent float64    `json:"completionPercent"`
+	Attempt           int        `json:"attempt,omitempty"`
+	RetryAfterMs      int64      `json:"retryAfterMs,omitempty"`
+	Reason            string     `json:"reason,omitempty"`
+	Error             string     `json:"error,omitempty"`
+	Plan              *Plan      `json:"plan,omitempty"`
+}
+
+// An event about slice i
+func sliceEvent(t EventType, i int) Event {
+	return Event{Type: t, Slice: &i}
+}
+
+// Sends an event to the metrics, the notifier and the caller's handler with the market and progress filled in. Events are delivered
+// one at a time even though slices run in their own goroutines
+func (e *execution) emit(event Event) {
+	event.Time = time.Now()
+	event.RunID = e.runID
+	event.Market = e.market
+	event.Side = e.side
+	if e.report != nil {
+		event.Executed, event.CompletionPercent = e.report.Progress()
+		event.Target = e.report.Target
+	}
+
+	e.eventsMu.Lock()
+	defer e.eventsMu.Unlock()
+	observeEvent(event)
+	e.notify(event)
+	if e.events != nil {
+		e.events(event)
+	}
+}
@@ -0,0 +1,100 @@
+package twap
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"math/big"
+	"testing"
+
+	"github.com/garry-sharp/enclave-assessment/pkg/api"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestEmit(t *testing.T) {
+	events := []Event{}
+	e := &execution{
+		ctx:    context.Background(),
+		cancel: func() {},
+		runID:  "run",
+		log:    slog.New(slog.NewTextHandler(io.Discard, nil)),
+		market: "AVAX-USDC",
+		side:   "buy",
+		events: func(event Event) { events = append(events, event) },
+		report: NewReport("AVAX-USDC", "buy", api.QUOTE, big.NewFloat(200), big.NewFloat(10), 4),
+	}
+
+	e.report.AddFill(NewSliceFill(0, big.NewFloat(50), api.CreateSpotOrderResponse{OrderId: "a", FilledSize: "5", FilledCost: "50", Fee: "0.05"}))
+	e.emit(sliceEvent(EventSliceFilled, 0))
+	e.abort(context.Background(), 1, big.NewFloat(50), errors.New("rejected"), "Order %d failed")
+	e.abort(context.Background(), 2, big.NewFloat(50), errors.New("rejected"), "Order %d failed")
+
+	// Filled, then a failed slice and a single aborted event, then only the second failed slice
+	types := []EventType{EventSliceFilled, EventSliceFailed, EventAborted, EventSliceFailed}
+	if len(events) != len(types) {
+		t.Fatalf("expected %d events, got: %+v", len(types), events)
+	}
+	for i, event := range events {
+		if event.Type != types[i] {
+			t.Errorf("event %d: expected %s, got %s", i, types[i], event.Type)
+		}
+	}
+
+	filled := events[0]
+	if filled.RunID != "run" || filled.Market != "AVAX-USDC" || filled.Side != "buy" || *filled.Slice != 0 || filled.Time.IsZero() {
+		t.Errorf("expected the run's fields to be set, got: %+v", filled)
+	}
+	if filled.Executed.String() != "50" || filled.Target.String() != "200" || filled.CompletionPercent != 25 {
+		t.Errorf("expected 50 of 200 executed, got: %s of %s (%f)", filled.Executed, filled.Target, filled.CompletionPercent)
+	}
+	if events[2].Reason != "Order 1 failed" || events[2].Error != "rejected" {
+		t.Errorf("expected the abort reason, got: %+v", events[2])
+	}
+
+	// The slice number is kept when it is 0 and fields that don't apply are left out
+	b, _ := json.Marshal(filled)
+	fields := map[string]any{}
+	json.Unmarshal(b, &fields)
+	if _, ok := fields["slice"]; !ok {
+		t.Errorf("expected slice 0 in %s", b)
+	}
+	if _, ok := fields["plan"]; ok {
+		t.Errorf("expected no plan in %s", b)
+	}
+
+	// No handler is fine
+	e.events = nil
+	e.emit(Event{Type: EventCompleted})
+}
+
+func TestObserveEvent(t *testing.T) {
+	filled := sliceEvent(EventSliceFilled, 0)
+	filled.Market, filled.Side = "METRICS-USDC", "sell"
+	filled.Executed, filled.Fee = big.NewFloat(5), big.NewFloat(0.25)
+
+	placed, filledSlices := slicesTotal.WithLabelValues("METRICS-USDC", "sell", "placed"), slicesTotal.WithLabelValues("METRICS-USDC", "sell", "filled")
+	placedBefore, filledBefore := testutil.ToFloat64(placed), testutil.ToFloat64(filledSlices)
+	for _, event := range []Event{
+		{Type: EventStarted, Market: "METRICS-USDC", Side: "sell", Target: big.NewFloat(20)},
+		{Type: EventSlicePlaced, Market: "METRICS-USDC", Side: "sell", Attempt: 1},
+		{Type: EventSlicePlaced, Market: "METRICS-USDC", Side: "sell", Attempt: 2},
+		filled, filled,
+	} {
+		observeEvent(event)
+	}
+
+	for name, values := range map[string][2]float64{
+		"placed":   {1, testutil.ToFloat64(placed) - placedBefore},
+		"filled":   {2, testutil.ToFloat64(filledSlices) - filledBefore},
+		"target":   {20, testutil.ToFloat64(targetGauge.WithLabelValues("METRICS-USDC", "sell"))},
+		"executed": {5, testutil.ToFloat64(executedGauge.WithLabelValues("METRICS-USDC", "sell"))},
+		"fees":     {0.5, testutil.ToFloat64(feesGauge.WithLabelValues("METRICS-USDC", "sell"))},
+	} {
+		if values[0] != values[1] {
+			t.Errorf("expected %s to be %v, got %v", name, values[0], values[1])
+		}
+	}
+}
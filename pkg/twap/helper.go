@@ -1,11 +1,15 @@
 package twap
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"fmt"
 	"math/big"
 	"regexp"
 	"strings"
 	"time"
+
+	"github.com/garry-sharp/enclave-assessment/pkg/api"
 )
 
 // simple sanity check on the input arguments. Returns an error if anything isn't supported.
@@ -58,8 +62,9 @@ func ValidateTwapArgs(side, amount, duration, market, interval, apiKey, apiSecre
 		return fmt.Errorf("api-secret must be provided")
 	}
 
-	if baseUrl != "https://api.enclave.market" && baseUrl != "https://api-staging.enclavemarket.dev" && baseUrl != "https://api-sandbox.enclave.market" {
-		return fmt.Errorf("base-url must be one of https://api.enclave.market, https://api-staging.enclavemarket.dev, https://api-sandbox.enclave.market")
+	// Whether the URL is allowed is checked against the allow-list separately, see api.ValidateBaseURL
+	if err := api.CheckBaseURL(baseUrl); err != nil {
+		return err
 	}
 
 	return nil
@@ -116,3 +121,154 @@ func GetQuantities(amount *big.Float, increment *big.Float, segments int) ([]*bi
 
 	return quantities, nil
 }
+
+// Helper function to round up a value to the nearest increment
+func RoundUp(value, increment *big.Float) *big.Float {
+	result := RoundDown(value, increment)
+	if result.Cmp(value) < 0 {
+		result.Add(result, increment)
+	}
+	return result
+}
+
+// Returns the smallest size a single slice can be without being rejected, denominated in the currency the slice is sized in.
+// Base sized slices can be no smaller than the base increment. Quote sized slices must be worth at least one base increment
+// at the given price, rounded up to the quote increment. The markets endpoint only gives the increments, not a minimum
+// order size or notional, so the exchange can still reject slices above this if it enforces one
+func MinimumSliceSize(denomination api.Denomination, baseIncrement, quoteIncrement, price *big.Float) *big.Float {
+	if denomination == api.BASE {
+		return new(big.Float).Set(baseIncrement)
+	}
+	notional := new(big.Float).Mul(baseIncrement, price)
+	return RoundUp(notional, quoteIncrement)
+}
+
+// Reduces the number of slices so that every slice is at least the minimum size, keeping as many as the minimum allows.
+// The caller spreads the new count over the duration, so the interval becomes duration / slices.
+func ConsolidateSlices(amount, minimum *big.Float, slices int) (int, error) {
+	if slices <= 0 {
+		return 0, fmt.Errorf("slices must be greater than zero")
+	}
+	if minimum.Sign() <= 0 {
+		return slices, nil
+	}
+
+	maxSlicesFloat := new(big.Float).Quo(amount, minimum)
+	maxSlicesInt, _ := maxSlicesFloat.Int(nil)
+	if !maxSlicesInt.IsInt64() || maxSlicesInt.Int64() >= int64(slices) {
+		return slices, nil
+	}
+
+	maxSlices := int(maxSlicesInt.Int64())
+	if maxSlices < 1 {
+		return 0, fmt.Errorf("amount %s is below the minimum order size of %s", amount.String(), minimum.String())
+	}
+	return maxSlices, nil
+}
+
+// Returns the denomination the amount is sized in. Defaults to the quote currency for buys and the base currency for sells
+func GetDenomination(side, denomination string) (api.Denomination, error) {
+	switch strings.ToLower(denomination) {
+	case "":
+		if strings.ToLower(side) == "buy" {
+			return api.QUOTE, nil
+		}
+		return api.BASE, nil
+	case string(api.BASE):
+		return api.BASE, nil
+	case string(api.QUOTE):
+		return api.QUOTE, nil
+	}
+	return "", fmt.Errorf("denomination must be either base or quote, received: %s", denomination)
+}
+
+// Converts an amount between the base and quote currency at the given price (quote per base)
+func ConvertAmount(amount, price *big.Float, from, to api.Denomination) (*big.Float, error) {
+	if from == to {
+		return new(big.Float).Set(amount), nil
+	}
+	if price == nil || price.Sign() <= 0 {
+		return nil, fmt.Errorf("a positive price is required to convert from %s to %s", from, to)
+	}
+	if from == api.BASE {
+		return new(big.Float).Mul(amount, price), nil
+	}
+	return new(big.Float).Quo(amount, price), nil
+}
+
+// Parses the optional min and max price into a band. Returns nil if neither is set
+func ParsePriceBand(minPrice, maxPrice string) (*PriceBand, error) {
+	if minPrice == "" && maxPrice == "" {
+		return nil, nil
+	}
+
+	band := &PriceBand{}
+	if minPrice != "" {
+		min, ok := big.NewFloat(0).SetString(minPrice)
+		if !ok || min.Sign() < 0 {
+			return nil, fmt.Errorf("min-price must be a valid positive number, received: %s", minPrice)
+		}
+		band.Min = min
+	}
+	if maxPrice != "" {
+		max, ok := big.NewFloat(0).SetString(maxPrice)
+		if !ok || max.Sign() <= 0 {
+			return nil, fmt.Errorf("max-price must be a valid positive number, received: %s", maxPrice)
+		}
+		band.Max = max
+	}
+	if band.Min != nil && band.Max != nil && band.Min.Cmp(band.Max) > 0 {
+		return nil, fmt.Errorf("min-price must be less than or equal to max-price")
+	}
+	return band, nil
+}
+
+// Parses how long the TWAP may run past its end time to place deferred slices. An empty value means no extension
+func ParseExtension(extension string) (time.Duration, error) {
+	if extension == "" {
+		return 0, nil
+	}
+	_extension, err := time.ParseDuration(extension)
+	if err != nil || _extension < 0 {
+		return 0, fmt.Errorf("extend must be a valid positive time duration, received: %s", extension)
+	}
+	return _extension, nil
+}
+
+// Parses the fraction of the amount reserved for fees in the balance check. Returns nil if not set
+func ParseFeeReserve(feeReserve string) (*big.Float, error) {
+	if feeReserve == "" {
+		return nil, nil
+	}
+	rate, ok := big.NewFloat(0).SetString(feeReserve)
+	if !ok || rate.Sign() < 0 || rate.Cmp(big.NewFloat(1)) >= 0 {
+		return nil, fmt.Errorf("fee-reserve must be a fraction between 0 and 1 e.g 0.001 for 0.1%%, received: %s", feeReserve)
+	}
+	return rate, nil
+}
+
+// Adds the fee reserve to an amount, i.e. amount * (1 + rate)
+func AddFeeReserve(amount, rate *big.Float) *big.Float {
+	reserve := new(big.Float).Mul(amount, rate)
+	return reserve.Add(reserve, amount)
+}
+
+// Parses how long the wait balance policy pauses for funds before aborting. Defaults to 5 minutes
+func ParseBalanceWait(balanceWait string) (time.Duration, error) {
+	if balanceWait == "" {
+		return 5 * time.Minute, nil
+	}
+	_balanceWait, err := time.ParseDuration(balanceWait)
+	if err != nil || _balanceWait <= 0 {
+		return 0, fmt.Errorf("balance-wait must be a valid positive time duration, received: %s", balanceWait)
+	}
+	return _balanceWait, nil
+}
+
+// Returns an ID for a run made of its UTC start time and random bytes, e.g. 20240501T120000Z-1a2b3c4d. IDs sort by start
+// time and are safe to use in file names
+func NewRunID() string {
+	b := make([]byte, 4)
+	rand.Read(b)
+	return time.Now().UTC().Format("20060102T150405Z") + "-" + hex.EncodeToString(b)
+}
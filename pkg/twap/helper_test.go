@@ -3,6 +3,9 @@ package twap
 import (
 	"math/big"
 	"testing"
+	"time"
+
+	"github.com/garry-sharp/enclave-assessment/pkg/api"
 )
 
 func TestValidateTwapArgs(t *testing.T) {
@@ -174,7 +177,7 @@ func TestValidateTwapArgs(t *testing.T) {
 			interval:  "1m",
 			apiKey:    "valid_key",
 			apiSecret: "valid_secret",
-			baseUrl:   "https://invalid-url.com",
+			baseUrl:   "invalid-url.com",
 			expectErr: true,
 		},
 	}
@@ -236,3 +239,170 @@ func TestGetQuantities(t *testing.T) {
 		t.Errorf("expected 13.91, got: %s", total.String())
 	}
 }
+
+func TestRoundUp(t *testing.T) {
+	res1 := RoundUp(big.NewFloat(1.23456), big.NewFloat(0.01))
+	res2 := RoundUp(big.NewFloat(1.2), big.NewFloat(0.1))
+
+	if res1.String() != "1.24" {
+		t.Errorf("expected 1.24, got: %s", res1.String())
+	}
+
+	if res2.String() != "1.2" {
+		t.Errorf("expected 1.2, got: %s", res2.String())
+	}
+}
+
+func TestMinimumSliceSize(t *testing.T) {
+	baseIncrement, _ := big.NewFloat(0).SetString("0.001")
+	quoteIncrement, _ := big.NewFloat(0).SetString("0.01")
+	price, _ := big.NewFloat(0).SetString("27.345")
+
+	sell := MinimumSliceSize(api.BASE, baseIncrement, quoteIncrement, price)
+	if sell.Text('f', 3) != "0.001" {
+		t.Errorf("expected 0.001, got: %s", sell.String())
+	}
+
+	buy := MinimumSliceSize(api.QUOTE, baseIncrement, quoteIncrement, price)
+	if buy.Text('f', 2) != "0.03" {
+		t.Errorf("expected 0.03, got: %s", buy.String())
+	}
+}
+
+func TestConsolidateSlices(t *testing.T) {
+	tests := []struct {
+		name      string
+		amount    float64
+		minimum   float64
+		slices    int
+		expected  int
+		expectErr bool
+	}{
+		{name: "Above minimum", amount: 100, minimum: 1, slices: 60, expected: 60},
+		{name: "Exact fit", amount: 1, minimum: 0.05, slices: 60, expected: 20},
+		{name: "Rounded down", amount: 1, minimum: 0.07, slices: 60, expected: 14},
+		{name: "Prime count", amount: 6, minimum: 1, slices: 7, expected: 6},
+		{name: "Single slice", amount: 1, minimum: 0.6, slices: 7, expected: 1},
+		{name: "Below minimum", amount: 0.01, minimum: 0.05, slices: 60, expectErr: true},
+		{name: "No slices", amount: 1, minimum: 0.05, slices: 0, expectErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, err := ConsolidateSlices(big.NewFloat(tt.amount), big.NewFloat(tt.minimum), tt.slices)
+			if (err != nil) != tt.expectErr {
+				t.Errorf("expected error: %v, got: %v", tt.expectErr, err)
+			}
+			if !tt.expectErr && res != tt.expected {
+				t.Errorf("expected %d, got: %d", tt.expected, res)
+			}
+		})
+	}
+}
+
+func TestGetDenomination(t *testing.T) {
+	tests := []struct {
+		side         string
+		denomination string
+		expected     api.Denomination
+		expectErr    bool
+	}{
+		{side: "buy", denomination: "", expected: api.QUOTE},
+		{side: "sell", denomination: "", expected: api.BASE},
+		{side: "buy", denomination: "BASE", expected: api.BASE},
+		{side: "sell", denomination: "quote", expected: api.QUOTE},
+		{side: "buy", denomination: "usd", expectErr: true},
+	}
+
+	for _, tt := range tests {
+		res, err := GetDenomination(tt.side, tt.denomination)
+		if (err != nil) != tt.expectErr {
+			t.Errorf("expected error: %v, got: %v", tt.expectErr, err)
+		}
+		if res != tt.expected {
+			t.Errorf("expected %s, got: %s", tt.expected, res)
+		}
+	}
+}
+
+func TestConvertAmount(t *testing.T) {
+	price := big.NewFloat(25)
+
+	res1, err1 := ConvertAmount(big.NewFloat(2), price, api.BASE, api.QUOTE)
+	res2, err2 := ConvertAmount(big.NewFloat(100), price, api.QUOTE, api.BASE)
+	res3, err3 := ConvertAmount(big.NewFloat(100), nil, api.QUOTE, api.QUOTE)
+	if err1 != nil || err2 != nil || err3 != nil {
+		t.Errorf("unexpected errors: %v, %v, %v", err1, err2, err3)
+	}
+	if res1.String() != "50" || res2.String() != "4" || res3.String() != "100" {
+		t.Errorf("unexpected values: %s, %s, %s", res1.String(), res2.String(), res3.String())
+	}
+
+	_, err := ConvertAmount(big.NewFloat(100), big.NewFloat(0), api.QUOTE, api.BASE)
+	if err == nil {
+		t.Errorf("expected error, got nil")
+	}
+}
+
+func TestParsePriceBand(t *testing.T) {
+	band, err := ParsePriceBand("", "")
+	if band != nil || err != nil {
+		t.Errorf("expected nil band and error, got: %v, %v", band, err)
+	}
+
+	band, err = ParsePriceBand("", "30")
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if !band.Contains(big.NewFloat(29.99)) || !band.Contains(big.NewFloat(30)) || band.Contains(big.NewFloat(30.01)) {
+		t.Errorf("unexpected band bounds: %s", band)
+	}
+
+	band, err = ParsePriceBand("20", "30")
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if band.Contains(big.NewFloat(19.99)) || !band.Contains(big.NewFloat(25)) {
+		t.Errorf("unexpected band bounds: %s", band)
+	}
+
+	for _, bounds := range [][2]string{{"30", "20"}, {"abc", ""}, {"", "-1"}} {
+		if _, err := ParsePriceBand(bounds[0], bounds[1]); err == nil {
+			t.Errorf("expected error for %v, got nil", bounds)
+		}
+	}
+}
+
+func TestParseExtension(t *testing.T) {
+	res1, err1 := ParseExtension("")
+	res2, err2 := ParseExtension("5m")
+	_, err3 := ParseExtension("-5m")
+	if err1 != nil || err2 != nil || err3 == nil {
+		t.Errorf("unexpected errors: %v, %v, %v", err1, err2, err3)
+	}
+	if res1 != 0 || res2 != 5*time.Minute {
+		t.Errorf("unexpected values: %s, %s", res1, res2)
+	}
+}
+
+func TestFeeReserve(t *testing.T) {
+	rate, err := ParseFeeReserve("0.001")
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	required := AddFeeReserve(big.NewFloat(1000), rate)
+	if required.Text('f', 2) != "1001.00" {
+		t.Errorf("expected 1001.00, got: %s", required.String())
+	}
+
+	rate, err = ParseFeeReserve("")
+	if rate != nil || err != nil {
+		t.Errorf("expected nil rate and error, got: %v, %v", rate, err)
+	}
+
+	for _, value := range []string{"abc", "-0.1", "1"} {
+		if _, err := ParseFeeReserve(value); err == nil {
+			t.Errorf("expected error for %s, got nil", value)
+		}
+	}
+}
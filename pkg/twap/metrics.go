@@ -0,0 +1,58 @@
+package twap
+
+import (
+	"math/big"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	slicesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "enclave_twap_slices_total",
+		Help: "TWAP slices by status: placed, filled, failed or deferred",
+	}, []string{"market", "side", "status"})
+	targetGauge = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "enclave_twap_target",
+		Help: "The amount the running TWAP is executing, in the amount's denomination",
+	}, []string{"market", "side"})
+	executedGauge = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "enclave_twap_executed",
+		Help: "The amount the running TWAP has executed so far, in the amount's denomination",
+	}, []string{"market", "side"})
+	feesGauge = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "enclave_twap_fees",
+		Help: "The fees the running TWAP has paid so far, in the quote currency",
+	}, []string{"market", "side"})
+)
+
+// Updates the metrics from an engine event
+func observeEvent(event Event) {
+	switch event.Type {
+	case EventStarted:
+		targetGauge.WithLabelValues(event.Market, event.Side).Set(toFloat(event.Target))
+		executedGauge.WithLabelValues(event.Market, event.Side).Set(0)
+		feesGauge.WithLabelValues(event.Market, event.Side).Set(0)
+	case EventSlicePlaced:
+		// Retries are counted by enclave_api_retries_total
+		if event.Attempt <= 1 {
+			slicesTotal.WithLabelValues(event.Market, event.Side, "placed").Inc()
+		}
+	case EventSliceFilled:
+		slicesTotal.WithLabelValues(event.Market, event.Side, "filled").Inc()
+		executedGauge.WithLabelValues(event.Market, event.Side).Set(toFloat(event.Executed))
+		feesGauge.WithLabelValues(event.Market, event.Side).Add(toFloat(event.Fee))
+	case EventSliceFailed:
+		slicesTotal.WithLabelValues(event.Market, event.Side, "failed").Inc()
+	case EventSliceDeferred:
+		slicesTotal.WithLabelValues(event.Market, event.Side, "deferred").Inc()
+	}
+}
+
+func toFloat(f *big.Float) float64 {
+	if f == nil {
+		return 0
+	}
+	v, _ := f.Float64()
+	return v
+}
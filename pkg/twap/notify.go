@@ -0,0 +1,206 @@
+package twap
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"time"
+
+	"github.com/garry-sharp/enclave-assessment/pkg/logger"
+)
+
+// The kind of a Notification. The values are part of the webhook payload and shouldn't be changed
+type NotificationType string
+
+const (
+	// The plan was confirmed and the first slice is about to be placed
+	NotifyStarted NotificationType = "started"
+	// The run has filled one of Milestones percent of its target
+	NotifyMilestone NotificationType = "milestone"
+	// A slice failed and won't be retried, the run carries on unless it is aborted
+	NotifyError NotificationType = "error"
+	// The run was stopped early, the remaining slices won't be placed
+	NotifyAborted NotificationType = "aborted"
+	// All slices have finished, successfully or not. Not sent after aborted, which ends the run's notifications
+	NotifyCompleted NotificationType = "completed"
+)
+
+// The percentages of the target filled that send a milestone notification. Reaching 100% sends completed instead
+var Milestones = []int{25, 50, 75}
+
+// A change in a run worth telling someone about, built from the engine's events. Executed and Target are in Asset, the
+// currency the amount is denominated in
+type Notification struct {
+	Type              NotificationType `json:"type"`
+	Time              time.Time        `json:"time"`
+	RunID             string           `json:"runId"`
+	Market            string           `json:"market"`
+	Side              string           `json:"side"`
+	Asset             string           `json:"asset"`
+	Executed          *big.Float       `json:"executed,omitempty"`
+	Target            *big.Float       `json:"target,omitempty"`
+	CompletionPercent float64          `json:"completionPercent"`
+	Slices            int              `json:"slices,omitempty"`
+	Milestone         int              `json:"milestone,omitempty"`
+	Slice             *int             `json:"slice,omitempty"`
+	Reason            string           `json:"reason,omitempty"`
+	Error             string           `json:"error,omitempty"`
+	// Set on completed
+	AveragePrice *big.Float `json:"averagePrice,omitempty"`
+	TotalFees    *big.Float `json:"totalFees,omitempty"`
+	SlicesFilled int        `json:"slicesFilled,omitempty"`
+	SlicesFailed int        `json:"slicesFailed,omitempty"`
+}
+
+// Sends notifications somewhere, e.g. a webhook. Notify is called from a single goroutine in the order notifications
+// happen, and a slow or failing notifier doesn't hold up the run
+type Notifier interface {
+	Notify(ctx context.Context, n Notification) error
+}
+
+// A one line summary of the notification, e.g. for chat messages
+func (n Notification) Text() string {
+	progress := fmt.Sprintf("%s of %s %s", formatAmount(n.Executed), formatAmount(n.Target), n.Asset)
+	switch n.Type {
+	case NotifyStarted:
+		return fmt.Sprintf("TWAP %s started: %s %s %s on %s in %d slices", n.RunID, n.Side, formatAmount(n.Target), n.Asset, n.Market, n.Slices)
+	case NotifyMilestone:
+		return fmt.Sprintf("TWAP %s is %d%% filled: %s on %s", n.RunID, n.Milestone, progress, n.Market)
+	case NotifyError:
+		return fmt.Sprintf("TWAP %s slice %d failed on %s: %s", n.RunID, deref(n.Slice), n.Market, n.Error)
+	case NotifyAborted:
+		return fmt.Sprintf("TWAP %s aborted on %s at %.1f%% filled (%s): %s: %s", n.RunID, n.Market, n.CompletionPercent, progress, n.Reason, n.Error)
+	case NotifyCompleted:
+		return fmt.Sprintf("TWAP %s completed on %s: %s (%.1f%%) filled in %d slices, %d failed, average price %s, fees %s", n.RunID, n.Market, progress, n.CompletionPercent, n.SlicesFilled, n.SlicesFailed, formatAmount(n.AveragePrice), formatAmount(n.TotalFees))
+	}
+	return fmt.Sprintf("TWAP %s %s", n.RunID, n.Type)
+}
+
+func formatAmount(f *big.Float) string {
+	if f == nil {
+		return "-"
+	}
+	return f.Text('f', -1)
+}
+
+func deref(i *int) int {
+	if i == nil {
+		return 0
+	}
+	return *i
+}
+
+// How long a notification has to be sent, including retries, and how long the end of a run waits for the last ones
+const (
+	notificationTimeout = 30 * time.Second
+	notificationWait    = 30 * time.Second
+)
+
+// Delivers a run's notifications in the background, in order. A nil *notifications sends nothing
+type notifications struct {
+	notifier Notifier
+	log      *slog.Logger
+	queue    chan Notification
+	done     chan struct{}
+	// The index in Milestones of the next milestone, only used from emit so it needs no lock
+	milestone int
+	// Set once aborted is sent, so completed isn't sent after it. Only used from emit
+	aborted bool
+}
+
+func startNotifications(notifier Notifier, log *slog.Logger) *notifications {
+	if notifier == nil {
+		return nil
+	}
+	n := &notifications{notifier: notifier, log: log, queue: make(chan Notification, 64), done: make(chan struct{})}
+	go n.run()
+	return n
+}
+
+func (n *notifications) run() {
+	defer close(n.done)
+	for notification := range n.queue {
+		ctx, cancel := context.WithTimeout(context.Background(), notificationTimeout)
+		if err := n.notifier.Notify(ctx, notification); err != nil {
+			n.log.Warn("unable to send notification", "type", notification.Type, logger.ErrorKey, err)
+		}
+		cancel()
+	}
+}
+
+// Queues the notification, dropping it if the notifier has fallen too far behind rather than holding up the run
+func (n *notifications) send(notification Notification) {
+	select {
+	case n.queue <- notification:
+	default:
+		n.log.Warn("notifications are queued up, dropping notification", "type", notification.Type)
+	}
+}
+
+// Waits up to wait for the queued notifications to be sent. Nothing can be sent after
+func (n *notifications) close(wait time.Duration) {
+	if n == nil {
+		return
+	}
+	close(n.queue)
+	select {
+	case <-n.done:
+	case <-time.After(wait):
+		n.log.Warn("notifications weren't sent before the run finished")
+	}
+}
+
+// Sends a notification if the event is one, called by emit
+func (e *execution) notify(event Event) {
+	n := e.notifications
+	if n == nil {
+		return
+	}
+	notification := Notification{
+		Time:              event.Time,
+		RunID:             event.RunID,
+		Market:            event.Market,
+		Side:              event.Side,
+		Asset:             e.asset,
+		Executed:          event.Executed,
+		Target:            event.Target,
+		CompletionPercent: event.CompletionPercent,
+		Slice:             event.Slice,
+		Reason:            event.Reason,
+		Error:             event.Error,
+	}
+	switch event.Type {
+	case EventStarted:
+		notification.Type = NotifyStarted
+		if event.Plan != nil {
+			notification.Slices = event.Plan.Slices
+		}
+	case EventSliceFilled:
+		// A fill can cross more than one milestone, only the highest is sent
+		milestone := 0
+		for n.milestone < len(Milestones) && event.CompletionPercent >= float64(Milestones[n.milestone]) {
+			milestone = Milestones[n.milestone]
+			n.milestone++
+		}
+		if milestone == 0 || event.CompletionPercent >= 100 {
+			return
+		}
+		notification.Type, notification.Milestone = NotifyMilestone, milestone
+	case EventSliceFailed:
+		notification.Type = NotifyError
+	case EventAborted:
+		notification.Type = NotifyAborted
+		n.aborted = true
+	case EventCompleted:
+		if n.aborted {
+			return
+		}
+		notification.Type = NotifyCompleted
+		notification.AveragePrice, notification.TotalFees = e.report.AveragePrice, e.report.TotalFees
+		notification.SlicesFilled, notification.SlicesFailed = e.report.SlicesFilled, e.report.SlicesFailed
+	default:
+		return
+	}
+	n.send(notification)
+}
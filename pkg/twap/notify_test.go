@@ -0,0 +1,98 @@
+package twap
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/garry-sharp/enclave-assessment/pkg/api"
+)
+
+// Keeps notifications for the test to check, failing the first one to check it doesn't stop the rest
+type notifyRecorder struct {
+	notifications []Notification
+}
+
+func (r *notifyRecorder) Notify(ctx context.Context, n Notification) error {
+	r.notifications = append(r.notifications, n)
+	if len(r.notifications) == 1 {
+		return errors.New("unavailable")
+	}
+	return nil
+}
+
+func TestNotify(t *testing.T) {
+	recorder := &notifyRecorder{}
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	e := &execution{
+		ctx:           context.Background(),
+		cancel:        func() {},
+		runID:         "run",
+		log:           log,
+		market:        "AVAX-USDC",
+		side:          "buy",
+		asset:         "USDC",
+		report:        NewReport("AVAX-USDC", "buy", api.QUOTE, big.NewFloat(200), big.NewFloat(10), 5),
+		notifications: startNotifications(recorder, log),
+	}
+
+	e.emit(Event{Type: EventStarted, Plan: &Plan{Slices: 5}})
+	fill := func(i int, cost string) {
+		e.report.AddFill(NewSliceFill(i, big.NewFloat(40), api.CreateSpotOrderResponse{OrderId: "a", FilledSize: "1", FilledCost: cost, Fee: "0.1"}))
+		e.emit(sliceEvent(EventSliceFilled, i))
+	}
+	fill(0, "40") // 20%, no milestone
+	fill(1, "40") // 40%, passes 25
+	fill(2, "80") // 80%, passes 50 and 75, only 75 is sent
+	e.emit(sliceEvent(EventSliceRetry, 3))
+	e.abort(context.Background(), 3, big.NewFloat(40), errors.New("rejected"), "Order %d failed")
+	// Aborting ends the notifications, completed isn't sent after it
+	e.report.Finalize(nil)
+	e.emit(Event{Type: EventCompleted})
+	e.notifications.close(time.Second)
+
+	types := []NotificationType{NotifyStarted, NotifyMilestone, NotifyMilestone, NotifyError, NotifyAborted}
+	if len(recorder.notifications) != len(types) {
+		t.Fatalf("expected %d notifications, got: %+v", len(types), recorder.notifications)
+	}
+	for i, n := range recorder.notifications {
+		if n.Type != types[i] || n.RunID != "run" || n.Market != "AVAX-USDC" || n.Asset != "USDC" {
+			t.Errorf("notification %d: expected %s for the run, got: %+v", i, types[i], n)
+		}
+	}
+
+	n := recorder.notifications
+	if n[0].Slices != 5 || n[0].Text() != "TWAP run started: buy 200 USDC on AVAX-USDC in 5 slices" {
+		t.Errorf("unexpected started notification: %+v, %s", n[0], n[0].Text())
+	}
+	if n[1].Milestone != 25 || n[2].Milestone != 75 || n[2].Text() != "TWAP run is 75% filled: 160 of 200 USDC on AVAX-USDC" {
+		t.Errorf("unexpected milestones: %+v, %s", n[1:3], n[2].Text())
+	}
+	if *n[3].Slice != 3 || n[3].Error != "rejected" || n[4].Reason != "Order 3 failed" {
+		t.Errorf("unexpected error and abort: %+v", n[3:5])
+	}
+
+	// A run that isn't aborted sends completed with the report's totals
+	recorder = &notifyRecorder{}
+	e.notifications = startNotifications(recorder, log)
+	e.emit(Event{Type: EventStarted, Plan: &Plan{Slices: 5}})
+	e.emit(Event{Type: EventCompleted})
+	e.notifications.close(time.Second)
+	if len(recorder.notifications) != 2 || recorder.notifications[1].Type != NotifyCompleted {
+		t.Fatalf("expected started and completed, got: %+v", recorder.notifications)
+	}
+	completed := recorder.notifications[1]
+	if completed.SlicesFilled != 3 || completed.SlicesFailed != 1 || completed.TotalFees == nil || !strings.Contains(completed.Text(), "160 of 200 USDC (80.0%) filled in 3 slices, 1 failed") {
+		t.Errorf("unexpected completed notification: %+v, %s", completed, completed.Text())
+	}
+
+	// Without a notifier nothing is sent and closing is a no-op
+	e.notifications = startNotifications(nil, log)
+	e.emit(Event{Type: EventCompleted})
+	e.notifications.close(time.Second)
+}
@@ -0,0 +1,88 @@
+package twap
+
+import (
+	"fmt"
+	"io"
+	"math/big"
+	"text/tabwriter"
+	"time"
+
+	"github.com/garry-sharp/enclave-assessment/pkg/api"
+)
+
+// What a TWAP run is about to do, built once the pre-trade checks pass. Amounts are in the denomination and notional is
+// in the quote currency at the arrival price
+type Plan struct {
+	Market        string           `json:"market"`
+	Side          string           `json:"side"`
+	Denomination  api.Denomination `json:"denomination"`
+	BaseAsset     string           `json:"baseAsset"`
+	QuoteAsset    string           `json:"quoteAsset"`
+	Requested     *big.Float       `json:"requested"`
+	Amount        *big.Float       `json:"amount"`
+	Slices        int              `json:"slices"`
+	Interval      time.Duration    `json:"interval"`
+	SmallestSlice *big.Float       `json:"smallestSlice"`
+	LargestSlice  *big.Float       `json:"largestSlice"`
+	Price         *big.Float       `json:"price"`
+	Notional      *big.Float       `json:"notional"`
+	StartTime     time.Time        `json:"startTime"`
+	Duration      time.Duration    `json:"duration"`
+	// How long past the end time slices deferred by the price band can still be placed
+	Extension time.Duration `json:"extension"`
+	BaseURL   string        `json:"baseUrl"`
+}
+
+// The asset the amount is in
+func (p *Plan) AmountAsset() string {
+	if p.Denomination == api.BASE {
+		return p.BaseAsset
+	}
+	return p.QuoteAsset
+}
+
+func (p *Plan) EndTime() time.Time {
+	return p.StartTime.Add(p.Duration)
+}
+
+func (p *Plan) WriteTable(w io.Writer) error {
+	asset := p.AmountAsset()
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "Environment\t%s (%s)\n", api.Environment(p.BaseURL), p.BaseURL)
+	fmt.Fprintf(tw, "Market\t%s\n", p.Market)
+	fmt.Fprintf(tw, "Side\t%s (%s denominated)\n", p.Side, p.Denomination)
+	if p.Requested != nil && p.Requested.Cmp(p.Amount) != 0 {
+		fmt.Fprintf(tw, "Amount\t%s %s (requested %s, rounded down to the market increment)\n", formatFloat(p.Amount), asset, formatFloat(p.Requested))
+	} else {
+		fmt.Fprintf(tw, "Amount\t%s %s\n", formatFloat(p.Amount), asset)
+	}
+	fmt.Fprintf(tw, "Slices\t%d every %s\n", p.Slices, p.Interval)
+	if p.SmallestSlice.Cmp(p.LargestSlice) == 0 {
+		fmt.Fprintf(tw, "Slice size\t%s %s\n", formatFloat(p.LargestSlice), asset)
+	} else {
+		fmt.Fprintf(tw, "Slice size\t%s to %s %s\n", formatFloat(p.SmallestSlice), formatFloat(p.LargestSlice), asset)
+	}
+	fmt.Fprintf(tw, "Mid price\t%s %s\n", formatFloat(p.Price), p.QuoteAsset)
+	fmt.Fprintf(tw, "Estimated notional\t%s %s\n", formatFloat(p.Notional), p.QuoteAsset)
+	fmt.Fprintf(tw, "Start\t%s\n", p.StartTime.Format(time.RFC3339))
+	if p.Extension > 0 {
+		fmt.Fprintf(tw, "End\t%s (up to %s later for slices deferred by the price band)\n", p.EndTime().Format(time.RFC3339), p.Extension)
+	} else {
+		fmt.Fprintf(tw, "End\t%s\n", p.EndTime().Format(time.RFC3339))
+	}
+	return tw.Flush()
+}
+
+// The smallest and largest slice quantities
+func sliceRange(quantities []*big.Float) (*big.Float, *big.Float) {
+	smallest, largest := quantities[0], quantities[0]
+	for _, q := range quantities[1:] {
+		if q.Cmp(smallest) < 0 {
+			smallest = q
+		}
+		if q.Cmp(largest) > 0 {
+			largest = q
+		}
+	}
+	return smallest, largest
+}
@@ -0,0 +1,62 @@
+package twap
+
+import (
+	"bytes"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/garry-sharp/enclave-assessment/pkg/api"
+)
+
+func TestPlanWriteTable(t *testing.T) {
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	plan := &Plan{
+		Market:        "AVAX-USDC",
+		Side:          "buy",
+		Denomination:  api.QUOTE,
+		BaseAsset:     "AVAX",
+		QuoteAsset:    "USDC",
+		Requested:     big.NewFloat(100.005),
+		Amount:        big.NewFloat(100),
+		Slices:        3,
+		Interval:      time.Minute,
+		SmallestSlice: big.NewFloat(33.33),
+		LargestSlice:  big.NewFloat(33.34),
+		Price:         big.NewFloat(25),
+		Notional:      big.NewFloat(100),
+		StartTime:     start,
+		Duration:      3 * time.Minute,
+		BaseURL:       api.SandboxBaseURL,
+	}
+
+	buf := &bytes.Buffer{}
+	if err := plan.WriteTable(buf); err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{
+		"sandbox (https://api-sandbox.enclave.market)",
+		"100 USDC (requested 100.005, rounded down",
+		"3 every 1m0s",
+		"33.33 to 33.34 USDC",
+		"Estimated notional  100 USDC",
+		"2024-01-01T12:03:00Z",
+	} {
+		if !strings.Contains(buf.String(), expected) {
+			t.Errorf("expected plan to contain %q, got:\n%s", expected, buf.String())
+		}
+	}
+
+	plan.Denomination = api.BASE
+	if plan.AmountAsset() != "AVAX" {
+		t.Errorf("expected AVAX, got: %s", plan.AmountAsset())
+	}
+}
+
+func TestSliceRange(t *testing.T) {
+	smallest, largest := sliceRange([]*big.Float{big.NewFloat(2), big.NewFloat(1), big.NewFloat(3)})
+	if smallest.String() != "1" || largest.String() != "3" {
+		t.Errorf("expected 1 and 3, got: %s and %s", smallest.String(), largest.String())
+	}
+}
@@ -0,0 +1,370 @@
+package twap
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/garry-sharp/enclave-assessment/pkg/api"
+)
+
+// The result of a single slice. Size is always in the base currency and cost in the quote currency
+type SliceFill struct {
+	Iteration int        `json:"iteration"`
+	OrderId   string     `json:"orderId,omitempty"`
+	Status    string     `json:"status"`
+	Timestamp time.Time  `json:"timestamp"`
+	Requested *big.Float `json:"requested"`
+	Size      *big.Float `json:"size"`
+	Cost      *big.Float `json:"cost"`
+	Price     *big.Float `json:"price,omitempty"`
+	Fee       *big.Float `json:"fee"`
+	Error     string     `json:"error,omitempty"`
+}
+
+// Builds the fill for a slice from the order response. Fields the server left empty are treated as zero
+func NewSliceFill(iteration int, requested *big.Float, order api.CreateSpotOrderResponse) SliceFill {
+	fill := SliceFill{
+		Iteration: iteration,
+		OrderId:   order.OrderId,
+		Status:    order.Status,
+		Timestamp: time.Now(),
+		Requested: requested,
+		Size:      parseFloatOrZero(order.FilledSize),
+		Cost:      parseFloatOrZero(order.FilledCost),
+		Fee:       parseFloatOrZero(order.Fee),
+	}
+	if fill.Size.Sign() > 0 {
+		fill.Price = new(big.Float).Quo(fill.Cost, fill.Size)
+	}
+	return fill
+}
+
+func parseFloatOrZero(value string) *big.Float {
+	f, ok := big.NewFloat(0).SetString(value)
+	if !ok {
+		return big.NewFloat(0)
+	}
+	return f
+}
+
+// Execution quality report for a TWAP run. Slippage is in basis points and positive when the execution was worse than
+// the benchmark, i.e. a higher price for a buy or a lower price for a sell. Benchmarks that couldn't be measured are nil.
+// Fees are charged in the quote currency, they are added to the cost of a buy and taken from the proceeds of a sell
+type Report struct {
+	RunID             string           `json:"runId"`
+	Market            string           `json:"market"`
+	Side              string           `json:"side"`
+	Denomination      api.Denomination `json:"denomination"`
+	StartTime         time.Time        `json:"startTime"`
+	EndTime           time.Time        `json:"endTime"`
+	Target            *big.Float       `json:"target"`
+	Executed          *big.Float       `json:"executed"`
+	CompletionPercent float64          `json:"completionPercent"`
+	SlicesPlanned     int              `json:"slicesPlanned"`
+	SlicesFilled      int              `json:"slicesFilled"`
+	SlicesFailed      int              `json:"slicesFailed"`
+	// Why the run was stopped before all of its slices were placed, empty if it wasn't
+	Aborted          string            `json:"aborted,omitempty"`
+	FilledSize       *big.Float        `json:"filledSize"`
+	FilledCost       *big.Float        `json:"filledCost"`
+	TotalFees        *big.Float        `json:"totalFees"`
+	NetCost          *big.Float        `json:"netCost,omitempty"`
+	NetProceeds      *big.Float        `json:"netProceeds,omitempty"`
+	NetAveragePrice  *big.Float        `json:"netAveragePrice"`
+	ArrivalPrice     *big.Float        `json:"arrivalPrice"`
+	AveragePrice     *big.Float        `json:"averagePrice"`
+	MarketTWAP       *big.Float        `json:"marketTwap"`
+	MarketVWAP       *big.Float        `json:"marketVwap"`
+	SlippageArrival  *float64          `json:"slippageVsArrivalBps"`
+	SlippageTWAP     *float64          `json:"slippageVsTwapBps"`
+	SlippageVWAP     *float64          `json:"slippageVsVwapBps"`
+	Slices           []SliceFill       `json:"slices"`
+	BalanceDecisions []BalanceDecision `json:"balanceDecisions"`
+
+	mu           sync.Mutex
+	priceSamples []*big.Float
+	runningFees  *big.Float
+}
+
+func NewReport(market, side string, denomination api.Denomination, target, arrivalPrice *big.Float, slices int) *Report {
+	return &Report{
+		Market:           market,
+		Side:             side,
+		Denomination:     denomination,
+		StartTime:        time.Now(),
+		Target:           target,
+		ArrivalPrice:     arrivalPrice,
+		SlicesPlanned:    slices,
+		Slices:           []SliceFill{},
+		BalanceDecisions: []BalanceDecision{},
+	}
+}
+
+// Records the result of a slice and returns the fees paid so far, safe to call from multiple goroutines
+func (r *Report) AddFill(fill SliceFill) *big.Float {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Slices = append(r.Slices, fill)
+	if r.runningFees == nil {
+		r.runningFees = big.NewFloat(0)
+	}
+	if fill.Fee != nil {
+		r.runningFees.Add(r.runningFees, fill.Fee)
+	}
+	return new(big.Float).Set(r.runningFees)
+}
+
+// Records a decision made by the balance policy, safe to call from multiple goroutines
+func (r *Report) AddBalanceDecision(decision BalanceDecision) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.BalanceDecisions = append(r.BalanceDecisions, decision)
+}
+
+// Records a mid price observed during the run, used for the market TWAP benchmark
+func (r *Report) AddPriceSample(price *big.Float) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.priceSamples = append(r.priceSamples, price)
+}
+
+// Returns the amount executed so far in the amount's denomination and the percentage of the target it makes up, safe to
+// call from multiple goroutines while the run is in progress
+func (r *Report) Progress() (*big.Float, float64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	executed := big.NewFloat(0)
+	for _, s := range r.Slices {
+		if s.Error != "" {
+			continue
+		}
+		if r.Denomination == api.QUOTE {
+			executed.Add(executed, s.Cost)
+		} else {
+			executed.Add(executed, s.Size)
+		}
+	}
+	percent := 0.0
+	if r.Target != nil && r.Target.Sign() > 0 {
+		percent, _ = new(big.Float).Quo(executed, r.Target).Float64()
+		percent *= 100
+	}
+	return executed, percent
+}
+
+// Marks the run as aborted for the reason
+func (r *Report) SetAborted(reason string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Aborted = reason
+}
+
+// Calculates the totals, averages and slippage once all slices have finished. vwap may be nil if it couldn't be fetched
+func (r *Report) Finalize(vwap *big.Float) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.EndTime = time.Now()
+	r.MarketVWAP = vwap
+	r.FilledSize = big.NewFloat(0)
+	r.FilledCost = big.NewFloat(0)
+	r.TotalFees = big.NewFloat(0)
+	r.SlicesFilled = 0
+	r.SlicesFailed = 0
+
+	for _, s := range r.Slices {
+		if s.Error != "" {
+			r.SlicesFailed++
+			continue
+		}
+		r.SlicesFilled++
+		r.FilledSize.Add(r.FilledSize, s.Size)
+		r.FilledCost.Add(r.FilledCost, s.Cost)
+		r.TotalFees.Add(r.TotalFees, s.Fee)
+	}
+
+	r.Executed = r.FilledSize
+	if r.Denomination == api.QUOTE {
+		r.Executed = r.FilledCost
+	}
+	if r.Target != nil && r.Target.Sign() > 0 {
+		r.CompletionPercent, _ = new(big.Float).Quo(r.Executed, r.Target).Float64()
+		r.CompletionPercent *= 100
+	}
+
+	net := new(big.Float).Add(r.FilledCost, r.TotalFees)
+	if r.Side == "sell" {
+		net.Sub(r.FilledCost, r.TotalFees)
+		r.NetProceeds = net
+	} else {
+		r.NetCost = net
+	}
+
+	if r.FilledSize.Sign() > 0 {
+		r.AveragePrice = new(big.Float).Quo(r.FilledCost, r.FilledSize)
+		r.NetAveragePrice = new(big.Float).Quo(net, r.FilledSize)
+	}
+
+	if len(r.priceSamples) > 0 {
+		sum := big.NewFloat(0)
+		for _, p := range r.priceSamples {
+			sum.Add(sum, p)
+		}
+		r.MarketTWAP = sum.Quo(sum, big.NewFloat(float64(len(r.priceSamples))))
+	}
+
+	r.SlippageArrival = slippageBps(r.Side, r.AveragePrice, r.ArrivalPrice)
+	r.SlippageTWAP = slippageBps(r.Side, r.AveragePrice, r.MarketTWAP)
+	r.SlippageVWAP = slippageBps(r.Side, r.AveragePrice, r.MarketVWAP)
+}
+
+// Slippage of the execution price against a benchmark in basis points, positive is worse than the benchmark
+func slippageBps(side string, price, benchmark *big.Float) *float64 {
+	if price == nil || benchmark == nil || benchmark.Sign() <= 0 {
+		return nil
+	}
+	diff := new(big.Float).Sub(price, benchmark)
+	if side == "sell" {
+		diff.Neg(diff)
+	}
+	bps, _ := diff.Quo(diff, benchmark).Float64()
+	bps *= 10000
+	return &bps
+}
+
+// Writes a human readable summary followed by a table of each slice
+func (r *Report) WriteTable(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "Market\t%s\n", r.Market)
+	fmt.Fprintf(tw, "Side\t%s (%s denominated)\n", r.Side, r.Denomination)
+	fmt.Fprintf(tw, "Duration\t%s\n", r.EndTime.Sub(r.StartTime).Round(time.Millisecond))
+	fmt.Fprintf(tw, "Executed\t%s of %s (%.2f%%)\n", formatFloat(r.Executed), formatFloat(r.Target), r.CompletionPercent)
+	fmt.Fprintf(tw, "Slices\t%d filled, %d failed, %d planned\n", r.SlicesFilled, r.SlicesFailed, r.SlicesPlanned)
+	if r.Aborted != "" {
+		fmt.Fprintf(tw, "Aborted\t%s\n", r.Aborted)
+	}
+	fmt.Fprintf(tw, "Filled size / cost\t%s / %s\n", formatFloat(r.FilledSize), formatFloat(r.FilledCost))
+	fmt.Fprintf(tw, "Total fees\t%s\n", formatFloat(r.TotalFees))
+	if r.Side == "sell" {
+		fmt.Fprintf(tw, "Net proceeds\t%s\n", formatFloat(r.NetProceeds))
+	} else {
+		fmt.Fprintf(tw, "Net cost\t%s\n", formatFloat(r.NetCost))
+	}
+	fmt.Fprintf(tw, "Average price\t%s (%s including fees)\n", formatFloat(r.AveragePrice), formatFloat(r.NetAveragePrice))
+	fmt.Fprintf(tw, "Arrival price\t%s\tslippage %s\n", formatFloat(r.ArrivalPrice), formatBps(r.SlippageArrival))
+	fmt.Fprintf(tw, "Market TWAP\t%s\tslippage %s\n", formatFloat(r.MarketTWAP), formatBps(r.SlippageTWAP))
+	fmt.Fprintf(tw, "Market VWAP\t%s\tslippage %s\n", formatFloat(r.MarketVWAP), formatBps(r.SlippageVWAP))
+	for _, d := range r.BalanceDecisions {
+		fmt.Fprintf(tw, "Balance policy\t%s at iteration %d, %s available, %s\n", d.Policy, d.Iteration, formatFloat(d.Available), d.Action)
+	}
+	fmt.Fprintln(tw)
+
+	fmt.Fprintln(tw, strings.Join(csvHeader, "\t"))
+	for _, row := range r.rows() {
+		fmt.Fprintln(tw, strings.Join(row, "\t"))
+	}
+	return tw.Flush()
+}
+
+func (r *Report) WriteJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(r)
+}
+
+// Writes one row per slice, the summary is only available in the table and JSON formats
+func (r *Report) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(csvHeader); err != nil {
+		return err
+	}
+	if err := cw.WriteAll(r.rows()); err != nil {
+		return err
+	}
+	return cw.Error()
+}
+
+// Writes the report to a file, the format is taken from the extension
+func (r *Report) Export(path string) error {
+	format, err := ReportFormat(path)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if format == "csv" {
+		return r.WriteCSV(file)
+	}
+	return r.WriteJSON(file)
+}
+
+// Returns the export format for a report path, either json or csv
+func ReportFormat(path string) (string, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		return "json", nil
+	case ".csv":
+		return "csv", nil
+	}
+	return "", fmt.Errorf("report must be a .json or .csv file, received: %s", path)
+}
+
+var csvHeader = []string{"iteration", "order_id", "status", "timestamp", "requested", "size", "cost", "price", "fee", "error"}
+
+// Slices ordered by iteration as string rows for the table and CSV formats
+func (r *Report) rows() [][]string {
+	r.mu.Lock()
+	slices := make([]SliceFill, len(r.Slices))
+	copy(slices, r.Slices)
+	r.mu.Unlock()
+
+	sort.SliceStable(slices, func(i, j int) bool { return slices[i].Iteration < slices[j].Iteration })
+
+	rows := make([][]string, 0, len(slices))
+	for _, s := range slices {
+		rows = append(rows, []string{
+			strconv.Itoa(s.Iteration),
+			s.OrderId,
+			s.Status,
+			s.Timestamp.Format(time.RFC3339Nano),
+			formatFloat(s.Requested),
+			formatFloat(s.Size),
+			formatFloat(s.Cost),
+			formatFloat(s.Price),
+			formatFloat(s.Fee),
+			s.Error,
+		})
+	}
+	return rows
+}
+
+func formatFloat(f *big.Float) string {
+	if f == nil {
+		return "-"
+	}
+	return f.Text('f', -1)
+}
+
+func formatBps(bps *float64) string {
+	if bps == nil {
+		return "-"
+	}
+	return fmt.Sprintf("%.2f bps", *bps)
+}
@@ -0,0 +1,136 @@
+package twap
+
+import (
+	"bytes"
+	"encoding/json"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/garry-sharp/enclave-assessment/pkg/api"
+)
+
+func TestNewSliceFill(t *testing.T) {
+	fill := NewSliceFill(3, big.NewFloat(50), api.CreateSpotOrderResponse{
+		OrderId:    "abc",
+		Status:     "filled",
+		FilledSize: "2",
+		FilledCost: "50",
+		Fee:        "0.05",
+	})
+
+	if fill.Iteration != 3 || fill.OrderId != "abc" || fill.Status != "filled" {
+		t.Errorf("unexpected fill: %+v", fill)
+	}
+	if fill.Price.String() != "25" {
+		t.Errorf("expected 25, got: %s", fill.Price.String())
+	}
+
+	empty := NewSliceFill(0, big.NewFloat(50), api.CreateSpotOrderResponse{})
+	if empty.Size.Sign() != 0 || empty.Fee.Sign() != 0 || empty.Price != nil {
+		t.Errorf("expected zero fill, got: %+v", empty)
+	}
+}
+
+func TestReportFinalize(t *testing.T) {
+	report := NewReport("AVAX-USDC", "buy", api.QUOTE, big.NewFloat(100), big.NewFloat(25), 3)
+	report.AddFill(NewSliceFill(1, big.NewFloat(50), api.CreateSpotOrderResponse{FilledSize: "1.96", FilledCost: "49", Fee: "0.1"}))
+	report.AddFill(NewSliceFill(0, big.NewFloat(50), api.CreateSpotOrderResponse{FilledSize: "2", FilledCost: "51", Fee: "0.1"}))
+	report.AddFill(SliceFill{Iteration: 2, Status: "failed", Error: "insufficient funds"})
+	report.AddPriceSample(big.NewFloat(24))
+	report.AddPriceSample(big.NewFloat(26))
+	report.Finalize(nil)
+
+	if report.SlicesFilled != 2 || report.SlicesFailed != 1 {
+		t.Errorf("expected 2 filled and 1 failed, got: %d, %d", report.SlicesFilled, report.SlicesFailed)
+	}
+	if report.Executed.String() != "100" || report.CompletionPercent != 100 {
+		t.Errorf("expected 100 executed, got: %s (%f%%)", report.Executed.String(), report.CompletionPercent)
+	}
+	if report.TotalFees.Text('f', 2) != "0.20" {
+		t.Errorf("expected 0.20 fees, got: %s", report.TotalFees.String())
+	}
+	if report.NetCost.Text('f', 2) != "100.20" || report.NetProceeds != nil {
+		t.Errorf("expected 100.20 net cost, got: %v, %v", report.NetCost, report.NetProceeds)
+	}
+	if report.AveragePrice.Text('f', 4) != "25.2525" {
+		t.Errorf("expected 25.2525, got: %s", report.AveragePrice.String())
+	}
+	if report.MarketTWAP.String() != "25" {
+		t.Errorf("expected 25, got: %s", report.MarketTWAP.String())
+	}
+	if report.SlippageArrival == nil || *report.SlippageArrival < 100 || *report.SlippageArrival > 102 {
+		t.Errorf("expected ~101 bps slippage, got: %v", report.SlippageArrival)
+	}
+	if report.SlippageVWAP != nil {
+		t.Errorf("expected no VWAP slippage, got: %v", *report.SlippageVWAP)
+	}
+}
+
+func TestSlippageBps(t *testing.T) {
+	buy := slippageBps("buy", big.NewFloat(101), big.NewFloat(100))
+	sell := slippageBps("sell", big.NewFloat(101), big.NewFloat(100))
+	if *buy != 100 || *sell != -100 {
+		t.Errorf("expected 100 and -100, got: %f, %f", *buy, *sell)
+	}
+	if slippageBps("buy", nil, big.NewFloat(100)) != nil {
+		t.Errorf("expected nil slippage")
+	}
+}
+
+func TestReportFormats(t *testing.T) {
+	report := NewReport("AVAX-USDC", "sell", api.BASE, big.NewFloat(2), big.NewFloat(25), 2)
+	report.AddFill(NewSliceFill(1, big.NewFloat(1), api.CreateSpotOrderResponse{OrderId: "b", FilledSize: "1", FilledCost: "25", Fee: "0.5"}))
+	fees := report.AddFill(NewSliceFill(0, big.NewFloat(1), api.CreateSpotOrderResponse{OrderId: "a", FilledSize: "1", FilledCost: "25", Fee: "0.5"}))
+	report.Finalize(big.NewFloat(25))
+
+	if fees.String() != "1" || report.NetProceeds.String() != "49" || report.NetAveragePrice.String() != "24.5" {
+		t.Errorf("unexpected fees: %s, %v, %v", fees.String(), report.NetProceeds, report.NetAveragePrice)
+	}
+
+	var csvOut bytes.Buffer
+	if err := report.WriteCSV(&csvOut); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(csvOut.String()), "\n")
+	if len(lines) != 3 || !strings.HasPrefix(lines[1], "0,a,") || !strings.HasPrefix(lines[2], "1,b,") {
+		t.Errorf("unexpected csv: %s", csvOut.String())
+	}
+
+	var jsonOut bytes.Buffer
+	if err := report.WriteJSON(&jsonOut); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	decoded := map[string]any{}
+	if err := json.Unmarshal(jsonOut.Bytes(), &decoded); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if _, ok := decoded["aborted"]; decoded["averagePrice"] != "25" || decoded["slippageVsVwapBps"] != float64(0) || ok {
+		t.Errorf("unexpected json: %s", jsonOut.String())
+	}
+
+	var tableOut bytes.Buffer
+	if err := report.WriteTable(&tableOut); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if !strings.Contains(tableOut.String(), "100.00%") || strings.Contains(tableOut.String(), "Aborted") {
+		t.Errorf("unexpected table: %s", tableOut.String())
+	}
+
+	// An aborted run says why in the table and JSON
+	report.SetAborted("Order 1 failed 3 times: timeout")
+	tableOut.Reset()
+	jsonOut.Reset()
+	report.WriteTable(&tableOut)
+	report.WriteJSON(&jsonOut)
+	if !strings.Contains(tableOut.String(), "Aborted             Order 1 failed 3 times: timeout") || !strings.Contains(jsonOut.String(), `"aborted": "Order 1 failed 3 times: timeout"`) {
+		t.Errorf("expected the abort reason, got: %s\n%s", tableOut.String(), jsonOut.String())
+	}
+
+	for path, expected := range map[string]string{"out.json": "json", "OUT.CSV": "csv", "out.txt": ""} {
+		format, _ := ReportFormat(path)
+		if format != expected {
+			t.Errorf("expected %q for %s, got: %q", expected, path, format)
+		}
+	}
+}
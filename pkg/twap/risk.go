@@ -0,0 +1,396 @@
+package twap
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/garry-sharp/enclave-assessment/pkg/api"
+	"github.com/garry-sharp/enclave-assessment/pkg/filelock"
+	"github.com/garry-sharp/enclave-assessment/pkg/logger"
+)
+
+// Returned when a run or a slice would break a risk limit. The message says which limit
+var ErrRiskLimit = errors.New("risk limit exceeded")
+
+// Limits checked before a run starts and again before each slice is placed. Notional is in the quote currency, a nil
+// limit or an empty list isn't checked
+type RiskLimits struct {
+	// The largest notional of a whole run
+	MaxNotional *big.Float
+	// The largest notional of a single slice
+	MaxSliceNotional *big.Float
+	// The most notional traded per market and account in a UTC day, across runs
+	MaxDailyNotional *big.Float
+	AllowedMarkets   []string
+	AllowedSides     []string
+}
+
+// Parses the risk limits, empty values are no limit
+func ParseRiskLimits(maxNotional, maxSliceNotional, maxDailyNotional string, allowedMarkets, allowedSides []string) (*RiskLimits, error) {
+	limits := &RiskLimits{AllowedMarkets: allowedMarkets}
+	var err error
+	if limits.MaxNotional, err = parseLimit("max-notional", maxNotional); err != nil {
+		return nil, err
+	}
+	if limits.MaxSliceNotional, err = parseLimit("max-slice-notional", maxSliceNotional); err != nil {
+		return nil, err
+	}
+	if limits.MaxDailyNotional, err = parseLimit("max-daily-notional", maxDailyNotional); err != nil {
+		return nil, err
+	}
+	for _, side := range allowedSides {
+		side = strings.ToLower(side)
+		if side != "buy" && side != "sell" {
+			return nil, fmt.Errorf("allowed-sides must only contain buy or sell, received: %s", side)
+		}
+		limits.AllowedSides = append(limits.AllowedSides, side)
+	}
+	return limits, nil
+}
+
+func parseLimit(name, value string) (*big.Float, error) {
+	if value == "" {
+		return nil, nil
+	}
+	limit, ok := big.NewFloat(0).SetString(value)
+	if !ok || limit.Sign() <= 0 {
+		return nil, fmt.Errorf("%s must be a positive number, received: %s", name, value)
+	}
+	return limit, nil
+}
+
+// Whether any limit is on notional, which needs a price to value the run and its slices
+func (l *RiskLimits) HasNotionalLimits() bool {
+	return l.MaxNotional != nil || l.MaxSliceNotional != nil || l.MaxDailyNotional != nil
+}
+
+// Checks the market and side are allowed
+func (l *RiskLimits) CheckOrder(market, side string) error {
+	if len(l.AllowedMarkets) > 0 && !containsFold(l.AllowedMarkets, market) {
+		return fmt.Errorf("%w: market %s is not in allowed-markets %s", ErrRiskLimit, market, strings.Join(l.AllowedMarkets, ", "))
+	}
+	if len(l.AllowedSides) > 0 && !containsFold(l.AllowedSides, side) {
+		return fmt.Errorf("%w: side %s is not in allowed-sides %s", ErrRiskLimit, side, strings.Join(l.AllowedSides, ", "))
+	}
+	return nil
+}
+
+// Checks a whole run before it starts, given its notional, the notional of its largest slice and what has already been
+// traded today
+func (l *RiskLimits) CheckRun(notional, largestSlice, tradedToday *big.Float) error {
+	if l.MaxNotional != nil && notional.Cmp(l.MaxNotional) > 0 {
+		return fmt.Errorf("%w: notional of %s exceeds max-notional of %s", ErrRiskLimit, notional.Text('f', -1), l.MaxNotional.Text('f', -1))
+	}
+	if err := l.checkSliceNotional(largestSlice); err != nil {
+		return err
+	}
+	return l.checkDaily(notional, tradedToday)
+}
+
+// Checks a slice before it is placed, given its notional at the current price and what has been traded or is in flight
+// today
+func (l *RiskLimits) CheckSlice(notional, tradedToday *big.Float) error {
+	if err := l.checkSliceNotional(notional); err != nil {
+		return err
+	}
+	return l.checkDaily(notional, tradedToday)
+}
+
+func (l *RiskLimits) checkSliceNotional(notional *big.Float) error {
+	if l.MaxSliceNotional != nil && notional.Cmp(l.MaxSliceNotional) > 0 {
+		return fmt.Errorf("%w: slice notional of %s exceeds max-slice-notional of %s", ErrRiskLimit, notional.Text('f', -1), l.MaxSliceNotional.Text('f', -1))
+	}
+	return nil
+}
+
+func (l *RiskLimits) checkDaily(notional, tradedToday *big.Float) error {
+	if l.MaxDailyNotional == nil {
+		return nil
+	}
+	total := new(big.Float).Add(tradedToday, notional)
+	if total.Cmp(l.MaxDailyNotional) > 0 {
+		return fmt.Errorf("%w: %s already traded today plus %s exceeds max-daily-notional of %s", ErrRiskLimit, tradedToday.Text('f', -1), notional.Text('f', -1), l.MaxDailyNotional.Text('f', -1))
+	}
+	return nil
+}
+
+func containsFold(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// Notional traded per account and market in the current UTC day, kept in a JSON file so the daily limit holds across
+// runs. Entries from previous days are dropped when it is next written
+type RiskLedger struct {
+	path    string
+	account string
+	mu      sync.Mutex
+}
+
+type ledgerFile struct {
+	Day string `json:"day"`
+	// Keyed by account/market
+	Traded map[string]string `json:"traded"`
+	// Notional held by slices in flight against the daily limit, keyed like Traded
+	Reserved map[string]string `json:"reserved,omitempty"`
+}
+
+// The ledger file used when --risk-ledger isn't set
+func DefaultRiskLedgerPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "enclave-twap", "risk-ledger.json"), nil
+}
+
+// Opens the ledger at path for the account the API key belongs to. The key itself isn't stored, only a hash of it
+func OpenRiskLedger(path, apiKey string) *RiskLedger {
+	hash := sha256.Sum256([]byte(apiKey))
+	return &RiskLedger{path: path, account: hex.EncodeToString(hash[:8])}
+}
+
+// The notional traded on the market today
+func (l *RiskLedger) Traded(market string) (*big.Float, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	ledger, err := l.read()
+	if err != nil {
+		return nil, err
+	}
+	return parseFloatOrZero(ledger.Traded[l.key(market)]), nil
+}
+
+// The notional traded on the market today plus what slices in flight have reserved, in this process or others
+func (l *RiskLedger) Used(market string) (*big.Float, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	ledger, err := l.read()
+	if err != nil {
+		return nil, err
+	}
+	key := l.key(market)
+	used := parseFloatOrZero(ledger.Traded[key])
+	return used.Add(used, parseFloatOrZero(ledger.Reserved[key])), nil
+}
+
+// Adds notional traded on the market today
+func (l *RiskLedger) Add(market string, notional *big.Float) error {
+	return l.Settle(market, nil, notional)
+}
+
+// Reserves notional on the market until it is settled. check is given what is used today, see Used, and nothing is
+// reserved if it fails. The ledger stays locked from the check until the reservation is written, so runs in other
+// processes can't both pass the daily limit on the same notional
+func (l *RiskLedger) Reserve(market string, notional *big.Float, check func(used *big.Float) error) error {
+	return l.update(func(ledger *ledgerFile) error {
+		key := l.key(market)
+		traded, reserved := parseFloatOrZero(ledger.Traded[key]), parseFloatOrZero(ledger.Reserved[key])
+		if err := check(new(big.Float).Add(traded, reserved)); err != nil {
+			return err
+		}
+		ledger.Reserved[key] = reserved.Add(reserved, notional).Text('f', -1)
+		return nil
+	})
+}
+
+// Releases notional reserved on the market and adds what was traded in its place, either may be nil
+func (l *RiskLedger) Settle(market string, reserved, traded *big.Float) error {
+	return l.update(func(ledger *ledgerFile) error {
+		key := l.key(market)
+		if reserved != nil {
+			// A reservation from before midnight was dropped with the rest of that day
+			left := parseFloatOrZero(ledger.Reserved[key])
+			if left.Sub(left, reserved).Sign() > 0 {
+				ledger.Reserved[key] = left.Text('f', -1)
+			} else {
+				delete(ledger.Reserved, key)
+			}
+		}
+		if traded != nil {
+			total := parseFloatOrZero(ledger.Traded[key])
+			ledger.Traded[key] = total.Add(total, traded).Text('f', -1)
+		}
+		return nil
+	})
+}
+
+// Reads the ledger, applies fn and writes it back. The ledger is locked throughout so runs in other processes can't
+// overwrite each other's entries, and nothing is written if the lock can't be taken or fn fails
+func (l *RiskLedger) update(fn func(ledger *ledgerFile) error) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	unlock, err := l.lock()
+	if err != nil {
+		return fmt.Errorf("unable to lock risk ledger %s: %w", l.path, err)
+	}
+	defer unlock()
+
+	ledger, err := l.read()
+	if err != nil {
+		return err
+	}
+	if err := fn(ledger); err != nil {
+		return err
+	}
+	return l.write(ledger)
+}
+
+// Locks a file next to the ledger, as the ledger itself is replaced on each write
+func (l *RiskLedger) lock() (func(), error) {
+	if err := os.MkdirAll(filepath.Dir(l.path), 0700); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(l.path+".lock", os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, err
+	}
+	if err := filelock.Lock(f); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return func() { f.Close() }, nil
+}
+
+func (l *RiskLedger) key(market string) string {
+	return l.account + "/" + strings.ToUpper(market)
+}
+
+func (l *RiskLedger) read() (*ledgerFile, error) {
+	today := time.Now().UTC().Format(time.DateOnly)
+	ledger := &ledgerFile{Day: today, Traded: map[string]string{}, Reserved: map[string]string{}}
+
+	raw, err := os.ReadFile(l.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return ledger, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	stored := &ledgerFile{}
+	if err := json.Unmarshal(raw, stored); err != nil {
+		return nil, fmt.Errorf("unable to parse risk ledger %s: %w", l.path, err)
+	}
+	if stored.Day != today || stored.Traded == nil {
+		return ledger, nil
+	}
+	if stored.Reserved == nil {
+		stored.Reserved = map[string]string{}
+	}
+	return stored, nil
+}
+
+// Writes to a temporary file and renames it so a crash can't leave a truncated ledger
+func (l *RiskLedger) write(ledger *ledgerFile) error {
+	raw, err := json.MarshalIndent(ledger, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(l.path), filepath.Base(l.path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(raw); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), l.path)
+}
+
+// Checks a slice against the risk limits at the current price and holds its notional against the daily limit until it
+// completes. Returns the notional held, nil if the slice couldn't be valued as there is no price and no notional limit
+func (e *execution) checkSliceRisk(qty, price *big.Float) (*big.Float, error) {
+	if price == nil && e.denomination == api.BASE && !e.risk.HasNotionalLimits() {
+		return nil, nil
+	}
+	notional, err := ConvertAmount(qty, price, e.denomination, api.QUOTE)
+	if err != nil {
+		return nil, err
+	}
+	check := func(used *big.Float) error {
+		return e.risk.CheckSlice(notional, used)
+	}
+
+	if e.reservesInLedger() {
+		if err := e.ledger.Reserve(e.market, notional, check); err != nil {
+			if errors.Is(err, ErrRiskLimit) {
+				return nil, err
+			}
+			return nil, fmt.Errorf("unable to reserve notional in the risk ledger for max-daily-notional: %w", err)
+		}
+		return notional, nil
+	}
+
+	// Without a ledger to reserve in, the slices in flight are held in memory and only count against this run
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if err := check(e.pendingNotional); err != nil {
+		return nil, err
+	}
+	e.pendingNotional.Add(e.pendingNotional, notional)
+	return notional, nil
+}
+
+// Records what a slice traded in the ledger and releases the notional held for it
+func (e *execution) settleSliceRisk(held, traded *big.Float) {
+	if traded != nil && traded.Sign() <= 0 {
+		traded = nil
+	}
+	if e.reservesInLedger() {
+		if held == nil && traded == nil {
+			return
+		}
+		if err := e.ledger.Settle(e.market, held, traded); err != nil {
+			e.log.Warn("unable to settle notional in the risk ledger", "held", held, "traded", traded, logger.ErrorKey, err)
+		}
+		return
+	}
+	if traded != nil && e.ledger != nil {
+		if err := e.ledger.Add(e.market, traded); err != nil {
+			e.log.Warn("unable to record notional traded in the risk ledger", "traded", traded, logger.ErrorKey, err)
+		}
+	}
+	if held != nil {
+		e.mu.Lock()
+		e.pendingNotional.Sub(e.pendingNotional, held)
+		e.mu.Unlock()
+	}
+}
+
+// Whether slices reserve their notional in the ledger, which is only needed to share a daily limit across processes
+func (e *execution) reservesInLedger() bool {
+	return e.ledger != nil && e.risk.MaxDailyNotional != nil
+}
+
+// The notional traded or reserved today from the ledger. Without a ledger nothing is tracked, and a ledger that can't be
+// read only matters if there is a daily limit to check
+func (e *execution) tradedToday() (*big.Float, error) {
+	if e.ledger == nil {
+		return big.NewFloat(0), nil
+	}
+	traded, err := e.ledger.Used(e.market)
+	if err != nil {
+		if e.risk.MaxDailyNotional != nil {
+			return nil, fmt.Errorf("unable to read risk ledger for max-daily-notional: %w", err)
+		}
+		return big.NewFloat(0), nil
+	}
+	return traded, nil
+}
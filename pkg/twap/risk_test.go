@@ -0,0 +1,233 @@
+package twap
+
+import (
+	"encoding/json"
+	"errors"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/garry-sharp/enclave-assessment/pkg/api"
+)
+
+func TestParseRiskLimits(t *testing.T) {
+	limits, err := ParseRiskLimits("1000", "", "5000", []string{"AVAX-USDC"}, []string{"BUY"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if limits.MaxNotional.String() != "1000" || limits.MaxSliceNotional != nil || limits.MaxDailyNotional.String() != "5000" {
+		t.Errorf("unexpected limits: %+v", limits)
+	}
+	if limits.AllowedSides[0] != "buy" {
+		t.Errorf("expected sides to be lower cased, got: %v", limits.AllowedSides)
+	}
+
+	for _, args := range [][3]string{{"-1", "", ""}, {"", "abc", ""}, {"", "", "0"}} {
+		if _, err := ParseRiskLimits(args[0], args[1], args[2], nil, nil); err == nil {
+			t.Errorf("expected %v to fail", args)
+		}
+	}
+	if _, err := ParseRiskLimits("", "", "", nil, []string{"short"}); err == nil {
+		t.Errorf("expected invalid side to fail")
+	}
+}
+
+func TestRiskLimits(t *testing.T) {
+	limits, _ := ParseRiskLimits("1000", "100", "1500", []string{"AVAX-USDC"}, []string{"buy"})
+
+	if err := limits.CheckOrder("avax-usdc", "buy"); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := limits.CheckOrder("BTC-USDC", "buy"); !errors.Is(err, ErrRiskLimit) {
+		t.Errorf("expected market not allowed, got: %v", err)
+	}
+	if err := limits.CheckOrder("AVAX-USDC", "sell"); !errors.Is(err, ErrRiskLimit) {
+		t.Errorf("expected side not allowed, got: %v", err)
+	}
+
+	tests := []struct {
+		name      string
+		notional  float64
+		largest   float64
+		traded    float64
+		expectErr bool
+	}{
+		{"Within limits", 1000, 100, 500, false},
+		{"Over max notional", 1000.01, 100, 0, true},
+		{"Over max slice notional", 500, 100.01, 0, true},
+		{"Over max daily notional", 1000, 100, 500.01, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := limits.CheckRun(big.NewFloat(tt.notional), big.NewFloat(tt.largest), big.NewFloat(tt.traded))
+			if (err != nil) != tt.expectErr {
+				t.Errorf("expected error: %v, got: %v", tt.expectErr, err)
+			}
+			if err != nil && !errors.Is(err, ErrRiskLimit) {
+				t.Errorf("expected ErrRiskLimit, got: %v", err)
+			}
+		})
+	}
+
+	if err := limits.CheckSlice(big.NewFloat(100), big.NewFloat(1400)); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := limits.CheckSlice(big.NewFloat(100), big.NewFloat(1400.01)); !errors.Is(err, ErrRiskLimit) {
+		t.Errorf("expected daily limit, got: %v", err)
+	}
+
+	none, _ := ParseRiskLimits("", "", "", nil, nil)
+	if err := none.CheckRun(big.NewFloat(1e12), big.NewFloat(1e12), big.NewFloat(1e12)); err != nil {
+		t.Errorf("expected no limits, got: %v", err)
+	}
+}
+
+func TestRiskLedger(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state", "ledger.json")
+	ledger := OpenRiskLedger(path, "key")
+
+	if traded, err := ledger.Traded("AVAX-USDC"); err != nil || traded.Sign() != 0 {
+		t.Errorf("expected nothing traded, got: %v, %v", traded, err)
+	}
+	ledger.Add("AVAX-USDC", big.NewFloat(100))
+	ledger.Add("avax-usdc", big.NewFloat(50.5))
+	ledger.Add("BTC-USDC", big.NewFloat(10))
+
+	// A second ledger on the same file sees the first one's trades, other keys don't
+	if traded, _ := OpenRiskLedger(path, "key").Traded("AVAX-USDC"); traded.String() != "150.5" {
+		t.Errorf("expected 150.5 traded, got: %s", traded.String())
+	}
+	if traded, _ := OpenRiskLedger(path, "other").Traded("AVAX-USDC"); traded.Sign() != 0 {
+		t.Errorf("expected nothing traded on another key, got: %s", traded.String())
+	}
+
+	raw, _ := os.ReadFile(path)
+	if string(raw) == "" || strings.Contains(string(raw), `"key/`) {
+		t.Errorf("expected the api key not to be stored, got: %s", raw)
+	}
+
+	// Entries from previous days are dropped
+	os.WriteFile(path, []byte(`{"day": "2000-01-01", "traded": {"x/AVAX-USDC": "999"}}`), 0600)
+	if traded, _ := ledger.Traded("AVAX-USDC"); traded.Sign() != 0 {
+		t.Errorf("expected previous day to be ignored, got: %s", traded.String())
+	}
+}
+
+func TestRiskLedgerConcurrent(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ledger.json")
+
+	// Each ledger has its own mutex and opens the lock file itself, like runs in separate processes
+	wg := sync.WaitGroup{}
+	for range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := OpenRiskLedger(path, "key").Add("AVAX-USDC", big.NewFloat(1)); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if traded, _ := OpenRiskLedger(path, "key").Traded("AVAX-USDC"); traded.String() != "20" {
+		t.Errorf("expected every add to be recorded, got: %s", traded.String())
+	}
+	// Only the ledger and its lock file are left behind
+	if entries, _ := os.ReadDir(filepath.Dir(path)); len(entries) != 2 {
+		t.Errorf("expected no temporary files left, got: %v", entries)
+	}
+}
+
+func TestRiskLedgerReserveConcurrent(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ledger.json")
+	limits, _ := ParseRiskLimits("", "", "100", nil, nil)
+
+	// Runs in separate processes each reserve 30 against a daily limit of 100, only three fit
+	var reserved atomic.Int32
+	wg := sync.WaitGroup{}
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			check := func(used *big.Float) error { return limits.CheckSlice(big.NewFloat(30), used) }
+			if OpenRiskLedger(path, "key").Reserve("AVAX-USDC", big.NewFloat(30), check) == nil {
+				reserved.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	if reserved.Load() != 3 {
+		t.Errorf("expected 3 reservations, got: %d", reserved.Load())
+	}
+
+	// Settling swaps a reservation for what was traded, and a fully settled market has nothing reserved
+	ledger := OpenRiskLedger(path, "key")
+	for range 3 {
+		ledger.Settle("AVAX-USDC", big.NewFloat(30), big.NewFloat(25))
+	}
+	if used, _ := ledger.Used("AVAX-USDC"); used.String() != "75" {
+		t.Errorf("expected 75 used, got: %s", used.String())
+	}
+	stored := &ledgerFile{}
+	raw, _ := os.ReadFile(path)
+	if err := json.Unmarshal(raw, stored); err != nil || len(stored.Reserved) != 0 {
+		t.Errorf("expected no reservation left, got: %s, %v", raw, err)
+	}
+}
+
+func TestCheckSliceRisk(t *testing.T) {
+	limits, _ := ParseRiskLimits("", "", "250", nil, nil)
+	e := &execution{
+		market:          "AVAX-USDC",
+		denomination:    api.BASE,
+		risk:            limits,
+		ledger:          OpenRiskLedger(filepath.Join(t.TempDir(), "ledger.json"), "key"),
+		pendingNotional: big.NewFloat(0),
+	}
+
+	// 10 AVAX at 10 is 100 notional, two fit under the daily limit while in flight and a third doesn't
+	first, err := e.checkSliceRisk(big.NewFloat(10), big.NewFloat(10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.checkSliceRisk(big.NewFloat(10), big.NewFloat(10)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.checkSliceRisk(big.NewFloat(10), big.NewFloat(10)); !errors.Is(err, ErrRiskLimit) {
+		t.Errorf("expected daily limit with slices in flight, got: %v", err)
+	}
+
+	// The first slice filled for less than estimated, leaving room for another
+	e.settleSliceRisk(first, big.NewFloat(40))
+	if _, err := e.checkSliceRisk(big.NewFloat(10), big.NewFloat(10)); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if traded, _ := e.ledger.Traded("AVAX-USDC"); traded.String() != "40" {
+		t.Errorf("expected 40 recorded, got: %s", traded.String())
+	}
+
+	// Another run on the same ledger, like one in a separate process, sees the notional reserved and traded
+	other := &execution{market: "AVAX-USDC", denomination: api.BASE, risk: limits, ledger: OpenRiskLedger(e.ledger.path, "key"), pendingNotional: big.NewFloat(0)}
+	if used, _ := other.tradedToday(); used.String() != "240" {
+		t.Errorf("expected 40 traded and 200 reserved, got: %s", used.String())
+	}
+	if _, err := other.checkSliceRisk(big.NewFloat(1.5), big.NewFloat(10)); !errors.Is(err, ErrRiskLimit) {
+		t.Errorf("expected daily limit with the other run's slices in flight, got: %v", err)
+	}
+
+	// A base denominated sell without notional limits can run without a price, it isn't held against anything
+	e.risk, _ = ParseRiskLimits("", "", "", nil, nil)
+	held, err := e.checkSliceRisk(big.NewFloat(10), nil)
+	if err != nil || held != nil {
+		t.Errorf("expected nothing held without a price, got: %v, %v", held, err)
+	}
+	e.settleSliceRisk(held, big.NewFloat(40))
+	e.risk, _ = ParseRiskLimits("", "100", "", nil, nil)
+	if _, err := e.checkSliceRisk(big.NewFloat(10), nil); err == nil {
+		t.Errorf("expected an error valuing the slice for max-slice-notional without a price")
+	}
+}
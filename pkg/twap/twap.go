@@ -2,7 +2,9 @@ package twap
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"log/slog"
 	"math/big"
 	"strings"
 	"sync"
@@ -11,65 +13,179 @@ import (
 
 	"github.com/garry-sharp/enclave-assessment/pkg/api"
 	"github.com/garry-sharp/enclave-assessment/pkg/logger"
+	"github.com/garry-sharp/enclave-assessment/pkg/tracing"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
-func ExecuteTwap(side, amount, duration, market, interval, apiKey, apiSecret, baseURL string) error {
+// Returned when a run is stopped before all of its slices are placed, e.g. by a fatal error or a risk limit. The report
+// is still returned with it, with Aborted set to the reason
+var ErrAborted = errors.New("TWAP aborted")
+
+// Runs a TWAP with the given arguments and returns the execution report. The report is nil if the TWAP fails before
+// any slices are placed, and is returned along with an error wrapping ErrAborted if it is aborted
+func ExecuteTwap(args TwapArgs) (report *Report, err error) {
+	side, amount, duration, market, interval := strings.ToLower(args.Side), args.Amount, args.Duration, args.Market, args.Interval
+	apiKey, apiSecret, baseURL := args.APIKey, args.APISecret, args.BaseURL
+	runID := args.RunID
+	if runID == "" {
+		runID = NewRunID()
+	}
+
+	// The run is the root span of its trace, each slice, attempt and API request is a span within it
+	runCtx, span := tracing.Start(context.Background(), "twap.run", attribute.String(logger.RunIDKey, runID), attribute.String(logger.MarketKey, market), attribute.String("side", side), attribute.String("amount", amount), attribute.String("duration", duration), attribute.String("interval", interval))
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+
+	// Every log from the run, including the api package's, carries its run ID and market, and its trace ID if traced
+	log := logger.With(logger.RunIDKey, runID, logger.MarketKey, market)
+	if traceID := tracing.TraceID(span); traceID != "" {
+		log = log.With(logger.TraceIDKey, traceID)
+	}
+	runCtx = logger.NewContext(runCtx, log)
 
 	// Perform initial sanity check on the input arguments
-	side = strings.ToLower(side)
-	err := ValidateTwapArgs(side, amount, duration, market, interval, apiKey, apiSecret, baseURL)
+	err = ValidateTwapArgs(side, amount, duration, market, interval, apiKey, apiSecret, baseURL)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	if args.AllowAnyBaseURL {
+		if api.ValidateBaseURL(baseURL, args.AllowedBaseURLs) != nil {
+			log.Warn("base-url is not in the allow-list, continuing as --insecure-allow-any-url is set", "base_url", baseURL)
+		}
+	} else if err := api.ValidateBaseURL(baseURL, args.AllowedBaseURLs); err != nil {
+		return nil, fmt.Errorf("%w, use --insecure-allow-any-url to allow it for local testing", err)
+	}
+	denomination, err := GetDenomination(side, args.Denomination)
+	if err != nil {
+		return nil, err
+	}
+	band, err := ParsePriceBand(args.MinPrice, args.MaxPrice)
+	if err != nil {
+		return nil, err
+	}
+	extension, err := ParseExtension(args.Extension)
+	if err != nil {
+		return nil, err
+	}
+	feeReserve, err := ParseFeeReserve(args.FeeReserve)
+	if err != nil {
+		return nil, err
+	}
+	balancePolicy, err := ParseBalancePolicy(args.BalancePolicy)
+	if err != nil {
+		return nil, err
+	}
+	balanceWait, err := ParseBalanceWait(args.BalanceWait)
+	if err != nil {
+		return nil, err
+	}
+	risk, err := ParseRiskLimits(args.MaxNotional, args.MaxSliceNotional, args.MaxDailyNotional, args.AllowedMarkets, args.AllowedSides)
+	if err != nil {
+		return nil, err
+	}
+	if err := risk.CheckOrder(market, side); err != nil {
+		return nil, err
+	}
+	var ledger *RiskLedger
+	if args.RiskLedger != "" {
+		ledger = OpenRiskLedger(args.RiskLedger, apiKey)
 	}
 
 	// Load API keys and check if user can log in with them
 	err = api.Load(apiKey, apiSecret, baseURL)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	timeoutCtx, cancelSyncClock := context.WithTimeout(runCtx, 5*time.Second)
+	defer cancelSyncClock()
+	if offset, err := api.SyncClock(timeoutCtx); err != nil {
+		log.Warn("unable to measure clock skew against the server, using the local clock", logger.ErrorKey, err)
+	} else if offset != 0 {
+		log.Info("correcting request timestamps for clock skew", "offset", offset)
 	}
-	timeoutCtx, cancelIsAuthed := context.WithTimeout(context.Background(), 5*time.Second)
+	timeoutCtx, cancelIsAuthed := context.WithTimeout(runCtx, 5*time.Second)
 	defer cancelIsAuthed()
 	if loggedIn := api.IsLoggedIn(timeoutCtx); !loggedIn {
-		return fmt.Errorf("not logged in")
+		return nil, fmt.Errorf("not logged in: %w", api.ErrAuth)
 	}
-	logger.Info("API keys valid") //TODO what if the API keys are read only
+	log.Info("API keys valid")
 
-	// Verify market exists and get the smallest increments
-
-	timeoutCtx, cancelSpotMarketDetails := context.WithTimeout(context.Background(), 5*time.Second)
+	// Verify market exists and get the smallest increments, before the permission probe is sent to it
+	timeoutCtx, cancelSpotMarketDetails := context.WithTimeout(runCtx, 5*time.Second)
 	defer cancelSpotMarketDetails()
 	baseName, baseIncrement, quoteName, quoteIncrement, err := api.GetSpotMarketDetails(timeoutCtx, market)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	var increment *big.Float
-	if side == "buy" {
+	increment := baseIncrement
+	if denomination == api.QUOTE {
 		increment = quoteIncrement
-	} else {
-		increment = baseIncrement
 	}
-	logger.Info("smallest increment for this market: ", increment)
+	log.Info("smallest increment for this market", "increment", increment)
+
+	// Check the keys can read balances and place orders before starting, rather than finding out on the first slice
+	timeoutCtx, cancelPermissions := context.WithTimeout(runCtx, 5*time.Second)
+	defer cancelPermissions()
+	permissions, err := api.GetKeyPermissions(timeoutCtx, market)
+	if err != nil {
+		return nil, fmt.Errorf("unable to check API key permissions: %w", err)
+	}
+	if missing := permissions.Missing(); len(missing) > 0 {
+		return nil, fmt.Errorf("API key is missing the %s permission(s): %w", strings.Join(missing, ", "), api.ErrPermissionDenied)
+	}
+	log.Info("API keys have read and trade permissions")
+
+	// Get the arrival price. Used to size the minimum slice in quote, to convert the amount into the asset being spent for
+	// the balance check, to value the run against the notional risk limits and as a benchmark in the report. A sell
+	// denominated in the base currency without notional limits only needs it for the report, so it can still run on a
+	// book without a mid price
+	needsPrice := side == "buy" || denomination == api.QUOTE || risk.HasNotionalLimits()
+	price, err := arrivalPrice(runCtx, log, market, needsPrice)
+	if err != nil {
+		return nil, err
+	}
 
 	// Reduce quantity to the nearest increment
 	quantity, okay := big.NewFloat(0).SetString(amount)
 	if !okay {
-		return fmt.Errorf("unable to parse amount")
+		return nil, fmt.Errorf("unable to parse amount")
 	}
 	q := RoundDown(quantity, increment)
 	if q.String() != quantity.String() {
-		logger.Info(fmt.Sprintf("minimum increment for this trading pair is %s, rounding %s amount down to %s", increment.String(), side, q.String()))
+		log.Info("rounding amount down to the minimum increment", "increment", increment, "side", side, "amount", q)
 	}
 	quantity = q
 
-	// Check if user has enough balance to execute the order
+	// Check if user has enough balance to execute the order. Buys spend the quote currency and sells spend the base
+	// currency, so the amount is converted at the mid price if it is denominated in the other one
 	balanceAsset := baseName
+	spendDenomination := api.BASE
 	if side == "buy" {
 		balanceAsset = quoteName
+		spendDenomination = api.QUOTE
 	}
-	timeoutCtx, cancelSufficientBalance := context.WithTimeout(context.Background(), 5*time.Second)
+	required, err := ConvertAmount(quantity, price, denomination, spendDenomination)
+	if err != nil {
+		return nil, err
+	}
+	if denomination != spendDenomination {
+		log.Info("amount estimated at the current mid price", "amount", quantity, "denomination", denomination, "required", required, "asset", balanceAsset)
+	}
+	if feeReserve != nil && side == "buy" {
+		required = AddFeeReserve(required, feeReserve)
+		log.Info("reserving part of the amount for fees", "fee_reserve", feeReserve, "required", required, "asset", balanceAsset)
+	}
+	timeoutCtx, cancelSufficientBalance := context.WithTimeout(runCtx, 5*time.Second)
 	defer cancelSufficientBalance()
-	if sufficient, err := api.SufficientSpotBalance(timeoutCtx, balanceAsset, quantity); !sufficient {
-		return err
+	if sufficient, err := api.SufficientSpotBalance(timeoutCtx, balanceAsset, required); !sufficient {
+		if err == nil {
+			err = fmt.Errorf("insufficient %s balance, %s required", balanceAsset, required.String())
+		}
+		return nil, err
 	}
 
 	// Calculate the number of iterations and the quantities to be traded
@@ -77,106 +193,527 @@ func ExecuteTwap(side, amount, duration, market, interval, apiKey, apiSecret, ba
 	_interval, _ := time.ParseDuration(interval)
 
 	iterations := int(_duration / _interval)
+
+	// Merge slices that would fall below the minimum order size
+	minimum := MinimumSliceSize(denomination, baseIncrement, quoteIncrement, price)
+	slices, err := ConsolidateSlices(quantity, minimum, iterations)
+	if err != nil {
+		return nil, err
+	}
+	if slices != iterations {
+		_interval = _duration / time.Duration(slices)
+		log.Warn("slices would be below the minimum order size, consolidating", "slices", iterations, "amount", quantity, "minimum", minimum, "consolidated", slices, "interval", _interval)
+		iterations = slices
+	}
+
 	quantities, err := GetQuantities(quantity, increment, iterations)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	// Create a ticker for the timer and set wait group to the number of iterations of the twap
-	ticker := time.NewTicker(_interval)
-	var wg sync.WaitGroup
-	wg.Add(iterations)
-	startTime := time.Now()
+	// Check the run against the risk limits at the arrival price before anything is placed. Without a price there are
+	// no notional limits to check, so the notional is left unknown
+	smallest, largest := sliceRange(quantities)
+	notional, largestNotional, err := runNotional(quantity, largest, price, denomination)
+	if err != nil {
+		return nil, err
+	}
 
 	// Create a context that can be canceled
-	ctx, cancel := context.WithCancel(context.Background())
+	ctx, cancel := context.WithCancel(runCtx)
 	defer cancel()
 
-	// Use sync.Once to ensure cancellation only happens once
-	var once sync.Once
-	stop := atomic.Bool{}
-	successfulIterations := int32(0)
+	e := &execution{
+		ctx:               ctx,
+		cancel:            cancel,
+		side:              side,
+		denomination:      denomination,
+		market:            market,
+		slices:            iterations,
+		increment:         increment,
+		minimum:           minimum,
+		balanceAsset:      balanceAsset,
+		spendDenomination: spendDenomination,
+		balancePolicy:     balancePolicy,
+		balanceWait:       balanceWait,
+		retryPolicy:       api.DefaultRetryPolicy(),
+		risk:              risk,
+		ledger:            ledger,
+		pendingNotional:   big.NewFloat(0),
+		inFlight:          big.NewFloat(0),
+		events:            args.Events,
+		log:               log,
+		runID:             runID,
+		asset:             baseName,
+		price:             price,
+	}
+	if denomination == api.QUOTE {
+		e.asset = quoteName
+	}
+	if side == "buy" {
+		e.feeReserve = feeReserve
+	}
+	tradedToday, err := e.tradedToday()
+	if err != nil {
+		return nil, err
+	}
+	if err := risk.CheckRun(notional, largestNotional, tradedToday); err != nil {
+		return nil, err
+	}
 
-	for i, qty := range quantities {
+	// Give the caller the chance to review the plan and back out before anything is placed
+	requested, _ := big.NewFloat(0).SetString(amount)
+	plan := &Plan{
+		Market:        market,
+		Side:          side,
+		Denomination:  denomination,
+		BaseAsset:     baseName,
+		QuoteAsset:    quoteName,
+		Requested:     requested,
+		Amount:        quantity,
+		Slices:        iterations,
+		Interval:      _interval,
+		SmallestSlice: smallest,
+		LargestSlice:  largest,
+		Price:         price,
+		Notional:      notional,
+		StartTime:     time.Now(),
+		Duration:      _duration,
+		Extension:     extension,
+		BaseURL:       baseURL,
+	}
+	if args.Confirm != nil {
+		if err := args.Confirm(plan); err != nil {
+			return nil, err
+		}
 
-		// Check if we should stop before waiting for the next interval
-		if stop.Load() {
-			logger.Info(fmt.Sprintf("skipping iteration %d due to cancellation", i))
-			wg.Done()
-			continue
+		// The prompt can be left open for any length of time, so the arrival price and the notional checked against the
+		// risk limits are taken again once it is confirmed, and the run starts from now
+		if price, err = arrivalPrice(runCtx, log, market, needsPrice); err != nil {
+			return nil, err
 		}
+		if notional, largestNotional, err = runNotional(quantity, largest, price, denomination); err != nil {
+			return nil, err
+		}
+		if tradedToday, err = e.tradedToday(); err != nil {
+			return nil, err
+		}
+		if err := risk.CheckRun(notional, largestNotional, tradedToday); err != nil {
+			return nil, err
+		}
+		e.price, plan.Price, plan.Notional, plan.StartTime = price, price, notional, time.Now()
+	}
+
+	// Create a ticker for the timer. Slices deferred by the price band can run on extra ticks up to the extension
+	ticker := time.NewTicker(_interval)
+	maxTicks := iterations - 1 + int(extension/_interval)
+	e.report = NewReport(market, side, denomination, quantity, price, iterations)
+	e.report.RunID = runID
+	span.SetAttributes(attribute.Int("slices", iterations))
+	e.notifications = startNotifications(args.Notifier, log)
+	defer e.notifications.close(notificationWait)
+	e.emit(Event{Type: EventStarted, Plan: plan})
+
+	i := 0
+	for tick := 0; i < len(quantities) && tick <= maxTicks; tick++ {
 
-		// If the first order, execute immediately; otherwise, wait for the interval or context cancellation
-		if i != 0 {
+		// If the first tick, execute immediately; otherwise, wait for the interval or context cancellation
+		if tick != 0 {
 			select {
 			case <-ticker.C:
-				go executeTrade(i, qty, &wg, ctx, cancel, &stop, &once, &successfulIterations, side, market)
 			case <-ctx.Done():
 				// Context was canceled while waiting for the ticker
-				logger.Info(fmt.Sprintf("skipping iteration %d due to cancellation during wait", i))
-				wg.Done()
+			}
+		}
+
+		// Check if we should stop before placing the next slice
+		if e.stop.Load() || ctx.Err() != nil {
+			log.Info("skipping remaining iterations due to cancellation", "from", i, "to", len(quantities)-1)
+			break
+		}
+
+		// Defer the slice while the balance policy is waiting for funds. The wait is bounded by balance-wait, so the tick
+		// isn't counted against the schedule and the slices left are placed once it resumes rather than dropped at the end
+		if e.paused.Load() > 0 {
+			log.Info("waiting for funds, deferring iteration", logger.SliceKey, i)
+			e.emitDeferred(nil, i, "waiting for funds")
+			maxTicks++
+			continue
+		}
+
+		// The slice's span starts at its tick, so time spent on the price and risk checks before it is placed is included
+		sliceCtx, sliceSpan := tracing.Start(ctx, "twap.slice", attribute.Int(logger.SliceKey, i), attribute.Int("tick", tick))
+
+		// Defer the slice to the next tick while the price is outside the band. Without a band the price is only sampled
+		// for the report and the risk checks, so it is fetched in the background rather than holding up the slice
+		if band != nil {
+			timeoutCtx, cancelMidPrice := context.WithTimeout(sliceCtx, 5*time.Second)
+			samplePrice, err := api.GetMidPrice(timeoutCtx, market)
+			cancelMidPrice()
+			if err != nil {
+				log.Error("unable to get price, deferring iteration", logger.SliceKey, i, logger.ErrorKey, err)
+				e.emitDeferred(sliceSpan, i, "price unavailable")
+				continue
+			}
+			e.addPriceSample(samplePrice)
+			if !band.Contains(samplePrice) {
+				log.Info("mid price is outside the band, deferring iteration", logger.SliceKey, i, "price", samplePrice, "band", band)
+				e.emitDeferred(sliceSpan, i, fmt.Sprintf("mid price %s is outside %s", samplePrice.String(), band))
 				continue
 			}
 		} else {
-			go executeTrade(i, qty, &wg, ctx, cancel, &stop, &once, &successfulIterations, side, market)
+			e.wg.Add(1)
+			go e.samplePrice(ctx)
+		}
+
+		// Check the slice against the risk limits at the latest price, the run stops if it would break one
+		qty := e.capSlice(quantities[i])
+		held, err := e.checkSliceRisk(qty, e.latestPrice())
+		if err != nil {
+			log.Error("slice blocked by a risk limit", logger.SliceKey, i, logger.ErrorKey, err)
+			e.abort(sliceCtx, i, qty, err, "Order %d blocked by a risk limit, canceling all orders")
+			sliceSpan.End()
+			break
 		}
 
+		e.wg.Add(1)
+		e.placed.Add(1)
+		go e.executeTrade(sliceCtx, i, qty, held)
+		i++
+	}
+	if i < len(quantities) && !e.stop.Load() {
+		log.Warn("end time reached with iterations not placed", "not_placed", len(quantities)-i, "slices", len(quantities))
 	}
 
-	wg.Wait()
+	e.wg.Wait()
 	ticker.Stop()
-	elapsed := time.Since(startTime)
-	logger.Info(fmt.Sprintf("TWAP completed in %s", elapsed))
-	logger.Info(fmt.Sprintf("completed iterations: %d", atomic.LoadInt32(&successfulIterations)))
-	return nil
+
+	// The VWAP is only a benchmark so the report is still returned without it
+	timeoutCtx, cancelVWAP := context.WithTimeout(runCtx, 5*time.Second)
+	defer cancelVWAP()
+	vwap, err := api.GetVWAP(timeoutCtx, market, e.report.StartTime, time.Now())
+	if err != nil {
+		log.Warn("unable to get market VWAP for the report", logger.ErrorKey, err)
+	}
+	e.report.Finalize(vwap)
+	if e.stop.Load() {
+		log.Error("TWAP aborted", "duration", e.report.EndTime.Sub(e.report.StartTime), "completed_iterations", atomic.LoadInt32(&e.successfulIterations), "reason", e.report.Aborted)
+		return e.report, fmt.Errorf("%w: %s", ErrAborted, e.report.Aborted)
+	}
+	e.emit(Event{Type: EventCompleted})
+
+	log.Info("TWAP completed", "duration", e.report.EndTime.Sub(e.report.StartTime), "completed_iterations", atomic.LoadInt32(&e.successfulIterations))
+	return e.report, nil
+}
+
+// The state shared between the TWAP loop and the goroutines placing each slice
+type execution struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	// Use sync.Once to ensure cancellation only happens once
+	once                 sync.Once
+	stop                 atomic.Bool
+	successfulIterations int32
+
+	side         string
+	denomination api.Denomination
+	market       string
+	report       *Report
+	// Carries the run ID and market
+	log   *slog.Logger
+	runID string
+
+	// Used by the balance policy when a slice is rejected for insufficient funds
+	slices            int
+	placed            atomic.Int32
+	increment         *big.Float
+	minimum           *big.Float
+	balanceAsset      string
+	spendDenomination api.Denomination
+	balancePolicy     BalancePolicy
+	balanceWait       time.Duration
+	retryPolicy       api.RetryPolicy
+	// The number of slices waiting for funds, no slices are placed while it is above zero
+	paused   atomic.Int32
+	mu       sync.Mutex
+	sliceCap *big.Float
+	// The quantities of the slices being placed, guarded by mu. feeReserve is only set for buys
+	inFlight   *big.Float
+	feeReserve *big.Float
+
+	// Checked before each slice. pendingNotional is held for slices in flight and guarded by mu
+	risk            *RiskLimits
+	ledger          *RiskLedger
+	pendingNotional *big.Float
+
+	// The caller's event handler, see emit
+	events   func(Event)
+	eventsMu sync.Mutex
+
+	// Sends the caller's notifications, nil if there is no notifier. asset is the currency the amount is in
+	notifications *notifications
+	asset         string
+
+	// The latest mid price sampled, nil if none has been. Guarded by mu
+	price *big.Float
+}
+
+// How long to keep trying to find out whether an order was placed after a request that may not have reached the exchange
+const reconcileTimeout = 15 * time.Second
+
+// The notional of the run and of its largest slice in the quote currency, nil without a price
+func runNotional(quantity, largest, price *big.Float, denomination api.Denomination) (*big.Float, *big.Float, error) {
+	if price == nil {
+		return nil, nil, nil
+	}
+	notional, err := ConvertAmount(quantity, price, denomination, api.QUOTE)
+	if err != nil {
+		return nil, nil, err
+	}
+	largestNotional, err := ConvertAmount(largest, price, denomination, api.QUOTE)
+	if err != nil {
+		return nil, nil, err
+	}
+	return notional, largestNotional, nil
 }
 
-func executeTrade(i int, qty *big.Float, wg *sync.WaitGroup, ctx context.Context, cancel context.CancelFunc, stop *atomic.Bool, once *sync.Once, successfulIterations *int32, side, market string) {
-	defer wg.Done()
+// Gets the arrival mid price. If the run doesn't need it a failure is only logged and the price is nil
+func arrivalPrice(ctx context.Context, log *slog.Logger, market string, needed bool) (*big.Float, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	price, err := api.GetMidPrice(ctx, market)
+	if err != nil {
+		if needed {
+			return nil, err
+		}
+		log.Warn("unable to get the arrival mid price, the report won't have an arrival benchmark", logger.ErrorKey, err)
+		return nil, nil
+	}
+	log.Info("arrival mid price", "price", price)
+	return price, nil
+}
+
+// The client order ID of slice i, the same on every attempt so an order whose request failed can be found before it is
+// sent again
+func (e *execution) clientOrderId(i int) string {
+	return fmt.Sprintf("%s-%d", e.runID, i)
+}
+
+// Places slice i, retrying with the retry policy. Fatal errors cancel all other slices immediately and 3 retryable
+// errors cancel all other slices. Transient errors don't count towards that threshold, if the policy is exhausted by
+// them only this slice fails. An attempt that may have reached the exchange despite failing is looked up by its client
+// order ID before another is sent, so a slice is never placed twice. held is the notional held against the daily risk
+// limit, released once the slice is done. ctx carries the slice's span, which is ended once the slice is done
+func (e *execution) executeTrade(ctx context.Context, i int, qty, held *big.Float) {
+	defer e.wg.Done()
+	span := trace.SpanFromContext(ctx)
+	defer span.End()
+	var traded *big.Float
+	defer func() { e.settleSliceRisk(held, traded) }()
+	// Shrinking resizes the quantity held, so the one released is the last one
+	e.hold(qty)
+	defer func() { e.release(qty) }()
+	clientOrderId := e.clientOrderId(i)
+	log := e.log.With(logger.SliceKey, i, "client_order_id", clientOrderId)
+	ctx = logger.NewContext(ctx, log)
+	retrier := api.NewRetrier(e.retryPolicy)
 	errorCount := 0
+	balanceAttempts := 0
 
-	for errorCount < 3 {
+	for {
 		// Check if the context has been canceled
 		select {
-		case <-ctx.Done():
-			logger.Info(fmt.Sprintf("order %d aborted due to cancellation", i))
+		case <-e.ctx.Done():
+			log.Info("order aborted due to cancellation")
 			return
 		default:
 		}
 
-		if errorCount > 0 {
-			time.Sleep(200 * time.Millisecond)
-			logger.Info(fmt.Sprintf("retrying order, iteration %d, amount = %s", i, qty.String()))
+		log.Info("creating order", "amount", qty)
+		placed := sliceEvent(EventSlicePlaced, i)
+		placed.Requested, placed.Attempt = qty, retrier.Attempts()+1
+		e.emit(placed)
+		attemptCtx, attempt := tracing.Start(ctx, "twap.attempt", attribute.Int("attempt", placed.Attempt), attribute.String("amount", qty.String()))
+		response := new(api.APIResponse[api.CreateSpotOrderResponse])
+		err := api.NewMarketOrder(attemptCtx, e.market, api.Side(e.side), e.denomination, qty, clientOrderId, response) // Use ctx here to support cancellation
+		tracing.RecordError(attempt, err)
+		attempt.End()
+
+		if api.Ambiguous(err) {
+			log.Warn("order may have been placed, looking it up before sending it again", logger.ErrorKey, err)
+			order, lookupErr := e.reconcileOrder(ctx, clientOrderId)
+			switch {
+			case lookupErr != nil:
+				// Sending it again could place the slice twice, so it is left for the user to check
+				err = fmt.Errorf("unable to tell whether order %s was placed after %w, not sending it again: %v", clientOrderId, err, lookupErr)
+				log.Error("slice failed", logger.ErrorKey, err)
+				e.report.AddFill(SliceFill{Iteration: i, Status: "failed", Timestamp: time.Now(), Requested: qty, Error: err.Error()})
+				e.emitFailed(ctx, i, qty, err)
+				return
+			case order != nil && parseFloatOrZero(order.FilledSize).Sign() > 0:
+				log.Info("order was placed despite the error", logger.OrderIDKey, order.OrderId)
+				response.Result, err = *order, nil
+			case order != nil:
+				// The client order ID is taken, so it can't be placed again under it
+				err = fmt.Errorf("order %s was %s without filling", order.OrderId, order.Status)
+				log.Error("slice failed", logger.ErrorKey, err)
+				e.report.AddFill(SliceFill{Iteration: i, OrderId: order.OrderId, Status: "failed", Timestamp: time.Now(), Requested: qty, Error: err.Error()})
+				e.emitFailed(ctx, i, qty, err)
+				return
+			}
 		}
 
-		logger.Info(fmt.Sprintf("creating order, iteration %d, amount = %s", i, qty.String()))
-		response := new(api.APIResponse[api.CreateSpotOrderResponse])
-		var err error
-		if side == "buy" {
-			err = api.NewMarketBuyOrder(ctx, market, qty, response) // Use ctx here to support cancellation
-		} else {
-			err = api.NewMarketSellOrder(ctx, market, qty, response) // Use ctx here to support cancellation
+		if err == nil {
+			fill := NewSliceFill(i, qty, response.Result)
+			fees := e.report.AddFill(fill)
+			traded = fill.Cost
+			if traded.Sign() == 0 {
+				// Not reported by the server, so the estimate is recorded rather than nothing
+				traded = held
+			}
+			log.Info("order created", logger.OrderIDKey, response.Result.OrderId, "amount", response.Result.Size, "fee", fill.Fee, "total_fees", fees)
+			filled := sliceEvent(EventSliceFilled, i)
+			filled.OrderId, filled.Requested, filled.Size, filled.Cost, filled.Price, filled.Fee = fill.OrderId, qty, fill.Size, fill.Cost, fill.Price, fill.Fee
+			e.emit(filled)
+			span.SetAttributes(attribute.String(logger.OrderIDKey, fill.OrderId), attribute.String("size", fill.Size.String()), attribute.String("price", fill.Price.String()))
+			atomic.AddInt32(&e.successfulIterations, 1)
+			return
 		}
 
-		if err != nil {
-			logger.Error(fmt.Sprintf("error creating order, iteration %d, %v", i, err))
+		if errors.Is(err, api.ErrInsufficientFunds) && balanceAttempts < 3 {
+			// Handled by the balance policy rather than counting towards the error threshold
+			balanceAttempts++
+			newQty, ok := e.handleInsufficientFunds(i, qty)
+			if !ok {
+				e.abort(ctx, i, qty, err, "Order %d rejected for insufficient funds, canceling all orders")
+				return
+			}
+			qty = newQty
+			continue
+		}
+
+		class := api.Classify(err)
+		log.Error("error creating order", "class", class, logger.ErrorKey, err)
+		if e.ctx.Err() != nil {
+			log.Info("order aborted due to cancellation")
+			return
+		}
+		if class == api.FATAL {
+			e.abort(ctx, i, qty, err, "Order %d failed with a fatal error, canceling all orders")
+			return
+		}
+		if class == api.RETRYABLE {
 			errorCount++
-		} else {
-			if response.Error != "" {
-				logger.Error(fmt.Sprintf("server error creating order, iteration %d, %v", i, response.Error))
-				errorCount++
-			} else {
-				logger.Info(fmt.Sprintf("%s order created, iteration %d, amount = %s", response.Result.OrderId, i, response.Result.Size))
-				atomic.AddInt32(successfulIterations, 1)
+			if errorCount >= 3 {
+				// If the error count exceeds the threshold, cancel all other goroutines
+				e.abort(ctx, i, qty, err, "Order %d failed 3 times, canceling all orders")
 				return
 			}
 		}
+
+		wait, ok := retrier.Next(err)
+		if !ok {
+			log.Error("retries exhausted", "attempts", retrier.Attempts())
+			e.report.AddFill(SliceFill{Iteration: i, Status: "failed", Timestamp: time.Now(), Requested: qty, Error: err.Error()})
+			e.emitFailed(ctx, i, qty, err)
+			return
+		}
+		log.Info("retrying order", "wait", wait.Round(time.Millisecond), "amount", qty)
+		retry := sliceEvent(EventSliceRetry, i)
+		retry.Requested, retry.Attempt, retry.RetryAfterMs, retry.Error = qty, retrier.Attempts(), wait.Milliseconds(), err.Error()
+		e.emit(retry)
+		if api.Sleep(e.ctx, wait) != nil {
+			log.Info("order aborted due to cancellation")
+			return
+		}
 	}
+}
 
-	// If the error count exceeds the threshold, cancel all other goroutines
-	once.Do(func() {
-		logger.Error(fmt.Sprintf("Order %d failed 3 times, canceling all orders", i))
-		stop.Store(true)
-		cancel()
+// Looks up the order sent for a slice after a request that may or may not have reached the exchange, retrying the lookup
+// with the retry policy. Returns nil if there is no such order, so it is safe to send again. The lookup outlives the
+// run's cancellation, so an order placed just before an abort is still reported
+func (e *execution) reconcileOrder(ctx context.Context, clientOrderId string) (*api.CreateSpotOrderResponse, error) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), reconcileTimeout)
+	defer cancel()
+	var order *api.CreateSpotOrderResponse
+	err := api.Retry(ctx, e.retryPolicy, func(ctx context.Context) error {
+		response := new(api.APIResponse[api.CreateSpotOrderResponse])
+		err := api.GetOrderByClientId(ctx, clientOrderId, response)
+		if errors.Is(err, api.ErrOrderNotFound) {
+			return nil
+		}
+		if err == nil {
+			order = &response.Result
+		}
+		return err
+	})
+	return order, err
+}
+
+// Samples the mid price for the report's market TWAP and the risk checks of later slices, off the tick so a slow
+// response doesn't delay the slice
+func (e *execution) samplePrice(ctx context.Context) {
+	defer e.wg.Done()
+	timeoutCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	price, err := api.GetMidPrice(timeoutCtx, e.market)
+	if err != nil {
+		if ctx.Err() == nil {
+			e.log.Warn("unable to sample the mid price", logger.ErrorKey, err)
+		}
+		return
+	}
+	e.addPriceSample(price)
+}
+
+func (e *execution) addPriceSample(price *big.Float) {
+	e.report.AddPriceSample(price)
+	e.mu.Lock()
+	e.price = price
+	e.mu.Unlock()
+}
+
+func (e *execution) latestPrice() *big.Float {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.price
+}
+
+// Records slice i as failed and cancels all other goroutines. ctx carries the slice's span
+func (e *execution) abort(ctx context.Context, i int, qty *big.Float, err error, message string) {
+	if err == nil {
+		err = fmt.Errorf("order %d aborted", i)
+	}
+	e.report.AddFill(SliceFill{Iteration: i, Status: "failed", Timestamp: time.Now(), Requested: qty, Error: err.Error()})
+	e.emitFailed(ctx, i, qty, err)
+	e.once.Do(func() {
+		reason := fmt.Sprintf(message, i)
+		e.log.Error(reason, logger.SliceKey, i, logger.ErrorKey, err)
+		e.stop.Store(true)
+		e.cancel()
+		e.report.SetAborted(reason + ": " + err.Error())
+		aborted := sliceEvent(EventAborted, i)
+		aborted.Reason, aborted.Error = reason, err.Error()
+		e.emit(aborted)
 	})
 }
+
+// Ends the tick's slice span if there is one, the slice gets a new span on the tick it is placed
+func (e *execution) emitDeferred(span trace.Span, i int, reason string) {
+	if span != nil {
+		span.SetAttributes(attribute.String("deferred", reason))
+		span.End()
+	}
+	deferred := sliceEvent(EventSliceDeferred, i)
+	deferred.Reason = reason
+	e.emit(deferred)
+}
+
+func (e *execution) emitFailed(ctx context.Context, i int, qty *big.Float, err error) {
+	tracing.RecordError(trace.SpanFromContext(ctx), err)
+	failed := sliceEvent(EventSliceFailed, i)
+	failed.Requested, failed.Error = qty, err.Error()
+	e.emit(failed)
+}
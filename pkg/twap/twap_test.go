@@ -0,0 +1,87 @@
+package twap
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/garry-sharp/enclave-assessment/pkg/api"
+)
+
+// An exchange whose order requests fail with a 502. The first order may have been placed anyway, in which case it is
+// found by its client order ID, otherwise it isn't found and the next request succeeds
+type flakyExchange struct {
+	*httptest.Server
+	mu        sync.Mutex
+	placed    bool
+	clientIds []string
+}
+
+func newFlakyExchange(placed bool) *flakyExchange {
+	x := &flakyExchange{placed: placed}
+	x.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		x.mu.Lock()
+		defer x.mu.Unlock()
+		order := `{"success":true,"result":{"orderId":"1","clientOrderId":"run-0","status":"filled","filledSize":"2","filledCost":"20","fee":"0.02"}}`
+		switch {
+		case r.Method == http.MethodPost && r.URL.Path == "/v1/orders":
+			request := api.SpotOrderRequest{}
+			json.NewDecoder(r.Body).Decode(&request)
+			x.clientIds = append(x.clientIds, request.ClientOrderId)
+			if len(x.clientIds) == 1 {
+				w.WriteHeader(http.StatusBadGateway)
+				return
+			}
+			w.Write([]byte(order))
+		case r.URL.Path == "/v1/orders/client:run-0" && x.placed:
+			w.Write([]byte(order))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	return x
+}
+
+func TestExecuteTradeReconciles(t *testing.T) {
+	for _, placed := range []bool{true, false} {
+		exchange := newFlakyExchange(placed)
+		defer exchange.Close()
+		api.Load("key", "secret", exchange.URL)
+
+		e := &execution{
+			ctx:             context.Background(),
+			cancel:          func() {},
+			runID:           "run",
+			log:             slog.New(slog.NewTextHandler(io.Discard, nil)),
+			market:          "AVAX-USDC",
+			side:            "buy",
+			denomination:    api.QUOTE,
+			report:          NewReport("AVAX-USDC", "buy", api.QUOTE, big.NewFloat(20), big.NewFloat(10), 1),
+			retryPolicy:     api.RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond},
+			risk:            &RiskLimits{},
+			pendingNotional: big.NewFloat(0),
+			inFlight:        big.NewFloat(0),
+		}
+		e.wg.Add(1)
+		e.executeTrade(context.Background(), 0, big.NewFloat(20), nil)
+
+		// Found after the 502 it isn't sent again, otherwise it is sent again under the same client order ID
+		expected := 2
+		if placed {
+			expected = 1
+		}
+		if len(exchange.clientIds) != expected || exchange.clientIds[0] != "run-0" || exchange.clientIds[len(exchange.clientIds)-1] != "run-0" {
+			t.Errorf("placed %v: expected %d requests for run-0, got: %v", placed, expected, exchange.clientIds)
+		}
+		if len(e.report.Slices) != 1 || e.report.Slices[0].OrderId != "1" || e.report.Slices[0].Error != "" {
+			t.Errorf("placed %v: expected order 1 filled, got: %+v", placed, e.report.Slices)
+		}
+	}
+}
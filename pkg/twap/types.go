@@ -0,0 +1,72 @@
+package twap
+
+import (
+	"fmt"
+	"math/big"
+)
+
+// The arguments for a single TWAP execution, these mirror the flags on the twap command
+type TwapArgs struct {
+	// Identifies the run in logs, events and the report. Generated if empty
+	RunID         string
+	Side          string
+	Amount        string
+	Duration      string
+	Market        string
+	Interval      string
+	Denomination  string
+	MinPrice      string
+	MaxPrice      string
+	Extension     string
+	FeeReserve    string
+	BalancePolicy string
+	BalanceWait   string
+	// Risk limits, see RiskLimits. RiskLedger is the file daily notional is tracked in, not tracked if empty
+	MaxNotional      string
+	MaxSliceNotional string
+	MaxDailyNotional string
+	AllowedMarkets   []string
+	AllowedSides     []string
+	RiskLedger       string
+	APIKey           string
+	APISecret        string
+	BaseURL          string
+	// Base URLs the TWAP may run against, defaults to the Enclave hosts if empty
+	AllowedBaseURLs []string
+	// Skips the allow-list check, for local mocks and testing only
+	AllowAnyBaseURL bool
+	// Called with the plan once all pre-trade checks pass and before any slice is placed. The run is canceled if it
+	// returns an error
+	Confirm func(plan *Plan) error
+	// Called with each progress event while the run is in progress, one at a time. Optional
+	Events func(event Event)
+	// Told when the run starts, passes each of Milestones, has a slice fail, aborts and completes. Optional
+	Notifier Notifier
+}
+
+// A range of mid prices slices are allowed to trade in. A nil bound is unbounded on that side
+type PriceBand struct {
+	Min *big.Float
+	Max *big.Float
+}
+
+func (b *PriceBand) Contains(price *big.Float) bool {
+	if b.Min != nil && price.Cmp(b.Min) < 0 {
+		return false
+	}
+	if b.Max != nil && price.Cmp(b.Max) > 0 {
+		return false
+	}
+	return true
+}
+
+func (b *PriceBand) String() string {
+	min, max := "-inf", "+inf"
+	if b.Min != nil {
+		min = b.Min.String()
+	}
+	if b.Max != nil {
+		max = b.Max.String()
+	}
+	return fmt.Sprintf("band [%s, %s]", min, max)
+}
@@ -0,0 +1,155 @@
+package twap
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/garry-sharp/enclave-assessment/pkg/api"
+)
+
+// The payloads a webhook can send. json is the Notification with its Text, slack is a message for a Slack incoming
+// webhook, which Mattermost, Rocket.Chat and Discord's /slack endpoint also accept
+const (
+	JSONWebhook  = "json"
+	SlackWebhook = "slack"
+)
+
+// The headers a signed payload is sent with, see SignWebhook
+const (
+	WebhookTimestampHeader = "X-Webhook-Timestamp"
+	WebhookSignatureHeader = "X-Webhook-Signature"
+)
+
+// Posts each notification to a URL, retrying network errors, 429s and 5xxs with exponential backoff
+type WebhookNotifier struct {
+	URL    string
+	Format string
+	// Signs each payload if set, see SignWebhook
+	Secret string
+	// How many times a notification is sent before giving up, and the wait before the first retry, doubled each time
+	Attempts int
+	Backoff  time.Duration
+	Client   *http.Client
+}
+
+func NewWebhookNotifier(webhookURL, format, secret string) (*WebhookNotifier, error) {
+	u, err := url.Parse(webhookURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("webhook url must be an http or https URL, received: %s", webhookURL)
+	}
+	if format == "" {
+		format = JSONWebhook
+	}
+	if format != JSONWebhook && format != SlackWebhook {
+		return nil, fmt.Errorf("webhook format must be json or slack, received: %s", format)
+	}
+	return &WebhookNotifier{URL: webhookURL, Format: format, Secret: secret, Attempts: 4, Backoff: time.Second, Client: &http.Client{Timeout: 10 * time.Second}}, nil
+}
+
+// The signature of a payload sent at timestamp, in Unix seconds. It is sent as sha256=<hex HMAC-SHA256 of
+// "<timestamp>.<body>" keyed by the secret>, so receivers can check the payload came from this tool and isn't a replay
+func SignWebhook(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// A response the webhook gave up on, e.g. a 404
+type webhookError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *webhookError) Error() string {
+	return fmt.Sprintf("webhook responded with status %d: %s", e.StatusCode, e.Body)
+}
+
+func (e *webhookError) retryable() bool {
+	return e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= http.StatusInternalServerError
+}
+
+func (w *WebhookNotifier) Notify(ctx context.Context, n Notification) error {
+	body, err := w.payload(n)
+	if err != nil {
+		return err
+	}
+
+	backoff := w.Backoff
+	for attempt := 1; ; attempt++ {
+		err = w.post(ctx, body)
+		var webhookErr *webhookError
+		if err == nil || attempt >= w.Attempts || ctx.Err() != nil || (errors.As(err, &webhookErr) && !webhookErr.retryable()) {
+			return err
+		}
+		if err := api.Sleep(ctx, backoff); err != nil {
+			return err
+		}
+		backoff *= 2
+	}
+}
+
+// Signed on each attempt so the timestamp is fresh
+func (w *WebhookNotifier) post(ctx context.Context, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if w.Secret != "" {
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		req.Header.Set(WebhookTimestampHeader, timestamp)
+		req.Header.Set(WebhookSignatureHeader, SignWebhook(w.Secret, timestamp, body))
+	}
+
+	resp, err := w.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		raw, _ := io.ReadAll(io.LimitReader(resp.Body, 256))
+		return &webhookError{StatusCode: resp.StatusCode, Body: string(bytes.TrimSpace(raw))}
+	}
+	return nil
+}
+
+func (w *WebhookNotifier) payload(n Notification) ([]byte, error) {
+	if w.Format == SlackWebhook {
+		return json.Marshal(map[string]string{"text": slackText(n)})
+	}
+	return json.Marshal(struct {
+		Notification
+		Text string `json:"text"`
+	}{n, n.Text()})
+}
+
+// Slack escapes these three characters in message text
+var slackEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+
+// A bold title with the run ID in code, then the summary
+func slackText(n Notification) string {
+	title := map[NotificationType]string{
+		NotifyStarted:   "TWAP started",
+		NotifyMilestone: fmt.Sprintf("TWAP %d%% filled", n.Milestone),
+		NotifyError:     "TWAP slice failed",
+		NotifyAborted:   "TWAP aborted",
+		NotifyCompleted: "TWAP completed",
+	}[n.Type]
+	if title == "" {
+		title = "TWAP " + string(n.Type)
+	}
+	return fmt.Sprintf("*%s* `%s`\n%s", title, slackEscaper.Replace(n.RunID), slackEscaper.Replace(n.Text()))
+}
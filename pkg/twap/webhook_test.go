@@ -0,0 +1,107 @@
+package twap
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// A webhook receiver answering each request with the next status, 200 once they run out
+type webhookServer struct {
+	*httptest.Server
+	mu       sync.Mutex
+	statuses []int
+	bodies   [][]byte
+	headers  []http.Header
+}
+
+func newWebhookServer(statuses ...int) *webhookServer {
+	s := &webhookServer{statuses: statuses}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.bodies = append(s.bodies, body)
+		s.headers = append(s.headers, r.Header)
+		if len(s.statuses) > 0 {
+			w.WriteHeader(s.statuses[0])
+			s.statuses = s.statuses[1:]
+		}
+	}))
+	return s
+}
+
+func TestNewWebhookNotifier(t *testing.T) {
+	if w, err := NewWebhookNotifier("https://example.com/hook", "", ""); err != nil || w.Format != JSONWebhook {
+		t.Errorf("expected the json format by default, got: %+v, %v", w, err)
+	}
+	for _, args := range [][2]string{{"example.com/hook", "json"}, {"ftp://example.com", "json"}, {"https://example.com", "xml"}} {
+		if _, err := NewWebhookNotifier(args[0], args[1], ""); err == nil {
+			t.Errorf("%v: expected error, got nil", args)
+		}
+	}
+}
+
+func TestWebhookNotifier(t *testing.T) {
+	slice := 2
+	n := Notification{Type: NotifyError, RunID: "run", Market: "AVAX-USDC", Side: "buy", Asset: "USDC", Slice: &slice, Error: "<rejected> & failed", Executed: big.NewFloat(50)}
+
+	// Retried through a 500 and a 429, signed on each attempt
+	server := newWebhookServer(http.StatusInternalServerError, http.StatusTooManyRequests)
+	defer server.Close()
+	w, _ := NewWebhookNotifier(server.URL, JSONWebhook, "secret")
+	w.Backoff = time.Millisecond
+	if err := w.Notify(context.Background(), n); err != nil {
+		t.Fatal(err)
+	}
+	if len(server.bodies) != 3 {
+		t.Fatalf("expected 3 attempts, got %d", len(server.bodies))
+	}
+	header := server.headers[2]
+	if header.Get(WebhookSignatureHeader) != SignWebhook("secret", header.Get(WebhookTimestampHeader), server.bodies[2]) || !strings.HasPrefix(header.Get(WebhookSignatureHeader), "sha256=") {
+		t.Errorf("signature doesn't match the payload: %v", header)
+	}
+	payload := map[string]any{}
+	json.Unmarshal(server.bodies[2], &payload)
+	if payload["type"] != "error" || payload["slice"] != 2.0 || payload["executed"] != "50" || payload["text"] != n.Text() {
+		t.Errorf("unexpected payload: %s", server.bodies[2])
+	}
+
+	// Not retried when the webhook rejects the payload, and given up on after Attempts
+	rejected := newWebhookServer(http.StatusNotFound)
+	defer rejected.Close()
+	w.URL = rejected.URL
+	if err := w.Notify(context.Background(), n); err == nil || len(rejected.bodies) != 1 || !strings.Contains(err.Error(), "404") {
+		t.Errorf("expected a single attempt with a 404, got %d: %v", len(rejected.bodies), err)
+	}
+	down := newWebhookServer(http.StatusBadGateway, http.StatusBadGateway, http.StatusBadGateway, http.StatusBadGateway, http.StatusBadGateway)
+	defer down.Close()
+	w.URL = down.URL
+	if err := w.Notify(context.Background(), n); err == nil || len(down.bodies) != w.Attempts {
+		t.Errorf("expected %d attempts, got %d: %v", w.Attempts, len(down.bodies), err)
+	}
+
+	// Slack messages are a single escaped text field and unsigned without a secret
+	slack := newWebhookServer()
+	defer slack.Close()
+	w, _ = NewWebhookNotifier(slack.URL, SlackWebhook, "")
+	if err := w.Notify(context.Background(), n); err != nil {
+		t.Fatal(err)
+	}
+	message := map[string]string{}
+	json.Unmarshal(slack.bodies[0], &message)
+	expected := "*TWAP slice failed* `run`\nTWAP run slice 2 failed on AVAX-USDC: &lt;rejected&gt; &amp; failed"
+	if len(message) != 1 || message["text"] != expected {
+		t.Errorf("expected %q, got %s", expected, slack.bodies[0])
+	}
+	if slack.headers[0].Get(WebhookSignatureHeader) != "" {
+		t.Errorf("expected no signature without a secret")
+	}
+}